@@ -0,0 +1,180 @@
+//       ___  _____  ____
+//      / _ \/  _/ |/_/ /____ ______ _
+//     / ___// /_>  </ __/ -_) __/  ' \
+//    /_/  /___/_/|_|\__/\__/_/ /_/_/_/
+//
+//    Copyright 2017 Eliuk Blau
+//
+//    This Source Code Form is subject to the terms of the Mozilla Public
+//    License, v. 2.0. If a copy of the MPL was not distributed with this
+//    file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/eliukblau/pixterm/pkg/ansimage"
+)
+
+// max size of cached rendered output, evicting least recently used outputs
+const renderCacheMaxBytes = 64 << 20 // 64 MiB
+
+// file extension of cached rendered output
+const renderCacheExt = ".ansi"
+
+// getCacheDir returns the pixterm directory in user cache directory (e.g. $XDG_CACHE_HOME),
+// or empty if there is no user cache directory.
+func getCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "pixterm")
+}
+
+// getRenderCacheDir returns the directory of cached rendered output.
+func getRenderCacheDir() (string, error) {
+	dir := getCacheDir()
+	if dir == "" {
+		return "", errors.New("no user cache directory")
+	}
+	return filepath.Join(dir, "render"), nil
+}
+
+// getCache returns the Cache of images downloaded from URLs with the flags (nil if disabled).
+func getCache() *ansimage.Cache {
+	if flagNoCache {
+		return nil
+	}
+	return &ansimage.Cache{Dir: getCacheDir(), Offline: flagOffline}
+}
+
+// purgeCache removes cached downloaded images and rendered output.
+func purgeCache() error {
+	if err := (&ansimage.Cache{Dir: getCacheDir()}).Purge(); err != nil {
+		return err
+	}
+	dir, err := getRenderCacheDir()
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// renderCacheKey returns the key of rendered output of an image: the hash of image data and
+// its modification time (only files), pixterm version, and everything that changes output.
+func renderCacheKey(file string, data []byte, ty, tx int, sm ansimage.ScaleMode, dm ansimage.DitheringMode, cd ansimage.ColorDepth, mc string) string {
+	var mtime time.Time
+	if info, err := os.Stat(file); err == nil {
+		mtime = info.ModTime()
+	}
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%x\n%d\n%s\n", sha256.Sum256(data), mtime.UnixNano(), pxtVersion)
+	fmt.Fprintln(hash, ty, tx, sm, dm, cd, mc, flagNoBg, flagPalette, flagGlyphs, flagASCII, flagInvert,
+		flagThres, flagDitherA, flagDitherS, flagNoSerp, flagGo, flagTol)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// loadRendered returns the cached rendered output of a key, and reports if it exists.
+func loadRendered(key string) ([]byte, bool) {
+	if flagNoCache {
+		return nil, false
+	}
+	dir, err := getRenderCacheDir()
+	if err != nil {
+		return nil, false
+	}
+	name := filepath.Join(dir, key+renderCacheExt)
+	out, err := os.ReadFile(name)
+	if err != nil {
+		return nil, false
+	}
+	now := time.Now()
+	os.Chtimes(name, now, now) // for LRU eviction
+	return out, true
+}
+
+// renderCached writes rendered output to a writer, streaming it to a temporary file in
+// render cache directory too, which is renamed to the cached output of key on success.
+// Cache errors are ignored, because output can always be rendered again.
+func renderCached(key string, w io.Writer, render func(w io.Writer) error) error {
+	if flagNoCache {
+		return render(w)
+	}
+	dir, err := getRenderCacheDir()
+	if err != nil || os.MkdirAll(dir, 0o700) != nil {
+		return render(w)
+	}
+	tmp, err := os.CreateTemp(dir, key+".*.tmp")
+	if err != nil {
+		return render(w)
+	}
+
+	cw := &cacheWriter{w: tmp}
+	err = render(io.MultiWriter(w, cw))
+	if closeErr := tmp.Close(); err != nil || cw.err != nil || closeErr != nil ||
+		os.Rename(tmp.Name(), filepath.Join(dir, key+renderCacheExt)) != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	evictRendered(dir)
+	return nil
+}
+
+// cacheWriter writes to a cache file, keeping its first error instead of returning it,
+// so output is not interrupted by cache errors.
+type cacheWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (cw *cacheWriter) Write(p []byte) (int, error) {
+	if cw.err == nil {
+		_, cw.err = cw.w.Write(p)
+	}
+	return len(p), nil
+}
+
+// evictRendered removes least recently used rendered outputs until cache is not bigger than max size.
+func evictRendered(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	var (
+		files []fs.FileInfo
+		size  int64
+	)
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), renderCacheExt) {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			files = append(files, info)
+			size += info.Size()
+		}
+	}
+
+	slices.SortFunc(files, func(a, b fs.FileInfo) int {
+		return a.ModTime().Compare(b.ModTime())
+	})
+	for _, info := range files {
+		if size <= renderCacheMaxBytes {
+			break
+		}
+		if os.Remove(filepath.Join(dir, info.Name())) == nil {
+			size -= info.Size()
+		}
+	}
+}
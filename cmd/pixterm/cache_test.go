@@ -0,0 +1,234 @@
+//       ___  _____  ____
+//      / _ \/  _/ |/_/ /____ ______ _
+//     / ___// /_>  </ __/ -_) __/  ' \
+//    /_/  /___/_/|_|\__/\__/_/ /_/_/_/
+//
+//    Copyright 2017 Eliuk Blau
+//
+//    This Source Code Form is subject to the terms of the Mozilla Public
+//    License, v. 2.0. If a copy of the MPL was not distributed with this
+//    file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eliukblau/pixterm/pkg/ansimage"
+)
+
+// setFlag sets a flag variable for the test, restoring its value at cleanup.
+func setFlag[T any](tb testing.TB, flag *T, value T) {
+	tb.Helper()
+	old := *flag
+	*flag = value
+	tb.Cleanup(func() { *flag = old })
+}
+
+func TestRenderCacheKey(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "image.png")
+	data := []byte("image data")
+	if err := os.WriteFile(file, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	key := func() string {
+		return renderCacheKey(file, data, 24, 80, ansimage.ScaleModeResize, ansimage.NoDithering, ansimage.ColorDepthTrueColor, "")
+	}
+	base := key()
+	if again := key(); again != base {
+		t.Fatalf("key changed without changes: %s, %s", base, again)
+	}
+
+	tests := []struct {
+		name string
+		key  func(t *testing.T) string
+	}{
+		{"data", func(t *testing.T) string {
+			return renderCacheKey(file, []byte("other data"), 24, 80, ansimage.ScaleModeResize, ansimage.NoDithering, ansimage.ColorDepthTrueColor, "")
+		}},
+		{"modification time", func(t *testing.T) string {
+			then := time.Now().Add(-time.Hour)
+			if err := os.Chtimes(file, then, then); err != nil {
+				t.Fatal(err)
+			}
+			return key()
+		}},
+		{"rows", func(t *testing.T) string {
+			return renderCacheKey(file, data, 25, 80, ansimage.ScaleModeResize, ansimage.NoDithering, ansimage.ColorDepthTrueColor, "")
+		}},
+		{"columns", func(t *testing.T) string {
+			return renderCacheKey(file, data, 24, 81, ansimage.ScaleModeResize, ansimage.NoDithering, ansimage.ColorDepthTrueColor, "")
+		}},
+		{"scale mode", func(t *testing.T) string {
+			return renderCacheKey(file, data, 24, 80, ansimage.ScaleModeFit, ansimage.NoDithering, ansimage.ColorDepthTrueColor, "")
+		}},
+		{"dithering mode", func(t *testing.T) string {
+			return renderCacheKey(file, data, 24, 80, ansimage.ScaleModeResize, ansimage.DitheringWithBraille, ansimage.ColorDepthTrueColor, "")
+		}},
+		{"color depth", func(t *testing.T) string {
+			return renderCacheKey(file, data, 24, 80, ansimage.ScaleModeResize, ansimage.NoDithering, ansimage.ColorDepthNone, "")
+		}},
+		{"matte", func(t *testing.T) string {
+			return renderCacheKey(file, data, 24, 80, ansimage.ScaleModeResize, ansimage.NoDithering, ansimage.ColorDepthTrueColor, "ffffff")
+		}},
+		{"version", func(t *testing.T) string { setFlag(t, &pxtVersion, pxtVersion+"-dev"); return key() }},
+		{"-nobg", func(t *testing.T) string { setFlag(t, &flagNoBg, true); return key() }},
+		{"-p", func(t *testing.T) string { setFlag(t, &flagPalette, "vga"); return key() }},
+		{"-g", func(t *testing.T) string { setFlag(t, &flagGlyphs, "all"); return key() }},
+		{"-ascii", func(t *testing.T) string { setFlag(t, &flagASCII, true); return key() }},
+		{"-invert", func(t *testing.T) string { setFlag(t, &flagInvert, true); return key() }},
+		{"-th", func(t *testing.T) string { setFlag(t, &flagThres, 100); return key() }},
+		{"-dt", func(t *testing.T) string { setFlag(t, &flagDitherA, 1); return key() }},
+		{"-ds", func(t *testing.T) string { setFlag(t, &flagDitherS, 0.5); return key() }},
+		{"-noserp", func(t *testing.T) string { setFlag(t, &flagNoSerp, true); return key() }},
+		{"-go", func(t *testing.T) string { setFlag(t, &flagGo, true); return key() }},
+		{"-tol", func(t *testing.T) string { setFlag(t, &flagTol, 8); return key() }},
+	}
+	seen := map[string]string{base: "base"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.key(t)
+			if other, ok := seen[got]; ok {
+				t.Errorf("key with changed %s is the same as with %s", tt.name, other)
+			}
+			seen[got] = tt.name
+		})
+	}
+}
+
+// renderCacheFiles returns the names of files in render cache directory.
+func renderCacheFiles(tb testing.TB) []string {
+	tb.Helper()
+	dir, err := getRenderCacheDir()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		tb.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestRenderCached(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	setFlag(t, &flagNoCache, false)
+
+	const key = "0123abcd"
+	if _, ok := loadRendered(key); ok {
+		t.Fatal("output is cached before render")
+	}
+
+	var out bytes.Buffer
+	err := renderCached(key, &out, func(w io.Writer) error {
+		_, err := io.WriteString(w, "rendered output")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "rendered output" {
+		t.Errorf("output = %q, want %q", out.String(), "rendered output")
+	}
+	if cached, ok := loadRendered(key); !ok || string(cached) != out.String() {
+		t.Errorf("cached output = %q, %v, want %q", cached, ok, out.String())
+	}
+	if files := renderCacheFiles(t); len(files) != 1 || files[0] != key+renderCacheExt {
+		t.Errorf("render cache has files %v, want only %s", files, key+renderCacheExt)
+	}
+}
+
+func TestRenderCachedFailure(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	setFlag(t, &flagNoCache, false)
+
+	errRender := errors.New("render failed")
+	var out bytes.Buffer
+	err := renderCached("0123abcd", &out, func(w io.Writer) error {
+		io.WriteString(w, "partial output")
+		return errRender
+	})
+	if !errors.Is(err, errRender) {
+		t.Errorf("renderCached error = %v, want %v", err, errRender)
+	}
+	if out.String() != "partial output" {
+		t.Errorf("output = %q, want %q", out.String(), "partial output")
+	}
+	if files := renderCacheFiles(t); len(files) != 0 {
+		t.Errorf("render cache has files %v after failed render, want none", files)
+	}
+	if _, ok := loadRendered("0123abcd"); ok {
+		t.Error("failed render is cached")
+	}
+}
+
+func TestRenderCachedDisabled(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	setFlag(t, &flagNoCache, true)
+
+	var out bytes.Buffer
+	if err := renderCached("0123abcd", &out, func(w io.Writer) error {
+		_, err := io.WriteString(w, "rendered output")
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if files := renderCacheFiles(t); len(files) != 0 {
+		t.Errorf("render cache has files %v with cache disabled, want none", files)
+	}
+}
+
+func TestEvictRendered(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	// sparse files of 24 MiB, from oldest to newest: 72 MiB are over the cap
+	const size = 24 << 20
+	names := []string{"a" + renderCacheExt, "b" + renderCacheExt, "c" + renderCacheExt, "d.1234.tmp"}
+	for i, name := range names {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Truncate(path, size); err != nil {
+			t.Fatal(err)
+		}
+		then := now.Add(time.Duration(i-len(names)) * time.Hour)
+		if err := os.Chtimes(path, then, then); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	evictRendered(dir)
+	for i, name := range names {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if wantEvicted := i == 0; errors.Is(err, os.ErrNotExist) != wantEvicted {
+			t.Errorf("%s: evicted %v, want %v", name, err != nil, wantEvicted)
+		}
+	}
+
+	// 48 MiB of cached output, and 16 MiB more reach the cap exactly
+	path := filepath.Join(dir, "e"+renderCacheExt)
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, renderCacheMaxBytes-2*size); err != nil {
+		t.Fatal(err)
+	}
+	evictRendered(dir)
+	for _, name := range names[1:] {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s: evicted at the cap: %v", name, err)
+		}
+	}
+}
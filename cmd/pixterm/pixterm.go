@@ -12,32 +12,92 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"flag"
 	"fmt"
+	"image"
+	"image/color"
 	"io"
 	"log"
+	"math"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"regexp"
 	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/eliukblau/pixterm/pkg/ansimage"
+	"github.com/eliukblau/pixterm/pkg/termdetect"
 	"github.com/lucasb-eyer/go-colorful"
 	"golang.org/x/term"
 )
 
+// pixterm output modes
+const (
+	outputAuto   = "auto"
+	outputANSI   = "ansi"
+	outputSixel  = "sixel"
+	outputKitty  = "kitty"
+	outputITerm2 = "iterm2"
+)
+
 var (
 	flagVersion bool
 	flagCredits bool
+	flagDelete  bool
+	flagID      uint
+	flagASCII   bool
+	flagColors  uint
 	flagDither  uint
+	flagDitherA uint
+	flagDitherS float64
+	flagGlyphs  string
 	flagGo      bool
+	flagInvert  bool
 	flagMatte   string
+	flagMono    bool
 	flagNoBg    bool
+	flagNoQuery bool
+	flagNoSerp  bool
+	flagOutput  string
+	flagOutDest string
+	flagPalette string
 	flagScale   uint
+	flagThres   uint
+	flagTol     uint
+	flagVerbose bool
 	flagRows    uint
 	flagCols    uint
+	flagTimeout time.Duration
+	flagHeaders headerFlags
+	flagNoCache bool
+	flagOffline bool
+	flagPurge   bool
 )
 
+// headerFlags are HTTP headers set by repeated flags in "Name: value" format.
+type headerFlags http.Header
+
+func (h headerFlags) String() string {
+	return ""
+}
+
+func (h headerFlags) Set(value string) error {
+	name, val, ok := strings.Cut(value, ":")
+	if !ok || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("header %q is not in \"Name: value\" format", value)
+	}
+	http.Header(h).Add(strings.TrimSpace(name), strings.TrimSpace(val))
+	return nil
+}
+
 func init() {
 	runtime.GOMAXPROCS(runtime.NumCPU()) // use paralelism for goroutines!
 	prepareLogoStuff()
@@ -45,6 +105,7 @@ func init() {
 }
 
 func main() {
+	parseFlags()
 	validateFlags()
 	runPixterm()
 }
@@ -78,10 +139,12 @@ func configureFlags() {
 
 		_, file := filepath.Split(os.Args[0])
 		fmt.Print("USAGE:\n\n")
-		fmt.Printf("  %s [options] image/url\n\n", file)
+		fmt.Printf("  %s [options] image/url\n", file)
+		fmt.Printf("  %s [options] - (or piped standard input)\n\n", file)
 
 		fmt.Print("  Supported image formats: JPEG, PNG, GIF, BMP, TIFF, WebP.\n")
-		fmt.Print("  Supported URL protocols: HTTP, HTTPS.\n\n")
+		fmt.Print("  Supported URL protocols: HTTP, HTTPS, data (base64 or URL-encoded), file.\n")
+		fmt.Print("  Supported archives: ZIP, TAR, TAR.GZ (e.g. archive.zip!/path/to/image).\n\n")
 
 		fmt.Print("OPTIONS:\n\n")
 		flag.CommandLine.SetOutput(os.Stdout)
@@ -96,15 +159,111 @@ func configureFlags() {
 
 	flag.CommandLine.BoolVar(&flagVersion, "version", false, "show PIXterm version")
 	flag.CommandLine.BoolVar(&flagCredits, "credits", false, "show some love to contributors <3")
-	flag.CommandLine.UintVar(&flagDither, "d", 0, "dithering `mode`:\n   0 - no dithering (default)\n   1 - with blocks\n   2 - with chars")
+	flag.CommandLine.BoolVar(&flagASCII, "ascii", false, "use only 7-bit ASCII chars\n(optional, only without color)")
+	flag.CommandLine.UintVar(&flagColors, "c", 0, "color `depth` (optional, default: auto-detected):\n   0 - true color\n   1 - 256 colors\n   2 - 16 colors\n   3 - 8 colors")
+	flag.CommandLine.UintVar(&flagDither, "d", 0, "dithering `mode`:\n   0 - no dithering (default)\n   1 - with blocks\n   2 - with chars\n   3 - with braille\n   4 - with quadrants\n   5 - with sextants\n   6 - with octants\n   7 - with glyph matching")
+	flag.CommandLine.UintVar(&flagDitherA, "dt", 0, "color dithering `algorithm` for reduced color depths\nor without color:\n   0 - none (default)\n   1 - Floyd-Steinberg\n   2 - Atkinson\n   3 - Jarvis-Judice-Ninke\n   4 - Sierra\n   5 - Stucki\n   6 - Bayer 2x2\n   7 - Bayer 4x4\n   8 - Bayer 8x8\n   9 - blue noise")
+	flag.CommandLine.Float64Var(&flagDitherS, "ds", 1, "color dithering `strength` (optional, 0-1, default: 1)")
+	flag.CommandLine.BoolVar(&flagDelete, "del", false, "delete previously placed images with same ID\n(optional, all images if no ID; only kitty output)")
+	flag.CommandLine.StringVar(&flagGlyphs, "g", "", "glyph `sets` for glyph matching dithering mode:\nascii, box, blocks, geometric (comma-separated),\nor all (optional, default: ascii,box,blocks)")
 	flag.CommandLine.BoolVar(&flagGo, "go", false, "output Go code to 'fmt.Print()' the image")
+	flag.CommandLine.UintVar(&flagID, "id", 0, "image `ID` to replace or delete it later\n(optional, only kitty output)")
+	flag.CommandLine.BoolVar(&flagInvert, "invert", false, "invert brightness ramp for light backgrounds\n(optional, only without color)")
 	flag.CommandLine.StringVar(&flagMatte, "m", "", "matte `color` for transparency or background\n(optional, hex format, default: 000000)")
+	flag.CommandLine.BoolVar(&flagMono, "mono", false, "plain text output without color nor escape sequences\n(optional, enabled if NO_COLOR environment variable is set)")
 	flag.CommandLine.BoolVar(&flagNoBg, "nobg", false, "disable background color\n(optional, only in dithering mode, ignores matte color)")
+	flag.CommandLine.BoolVar(&flagNoQuery, "noquery", false, "disable terminal query to detect color depth\n(optional, only environment variables are inspected)")
+	flag.CommandLine.BoolVar(&flagNoSerp, "noserp", false, "disable serpentine scanning in color dithering\n(optional, only error diffusion)")
+	flag.CommandLine.StringVar(&flagOutput, "o", "auto", "output `mode`:\n   auto - auto-detected (default)\n   ansi - ANSI escape codes\n   sixel - DEC sixel graphics\n   kitty - kitty graphics protocol\n   iterm2 - iTerm2 inline images protocol")
+	flag.CommandLine.StringVar(&flagOutDest, "out", "", "output `destination`: file path, /dev/stderr,\ntcp://host:port or unix:///path/to/socket\n(optional, default: standard output)")
+	flag.CommandLine.StringVar(&flagPalette, "p", "", "terminal `palette` for 16 and 8 colors depths:\nxterm (default), vga, solarized, tango,\nor 16 comma-separated colors (hex format)")
 	flag.CommandLine.UintVar(&flagScale, "s", 0, "scale `method`:\n   0 - resize (default)\n   1 - fill\n   2 - fit")
+	flag.CommandLine.UintVar(&flagThres, "th", 0, "brightness `threshold` to light braille dots\nor mosaic sub-cells without color\n(optional, 1-255, default: adaptive)")
+	flag.CommandLine.UintVar(&flagTol, "tol", 0, "color `tolerance` to reuse colors of previous cells,\nreducing output size (optional, 0-255, default: 0)")
+	flag.CommandLine.BoolVar(&flagVerbose, "v", false, "verbose mode: report output size to standard error")
 	flag.CommandLine.UintVar(&flagRows, "tr", 0, "terminal `rows` (optional, >=2; when piping, default: 24)")
 	flag.CommandLine.UintVar(&flagCols, "tc", 0, "terminal `columns` (optional, >=2; when piping, default: 80)")
+	flag.CommandLine.DurationVar(&flagTimeout, "timeout", ansimage.DefaultFetchTimeout, "`timeout` to download images from URLs (optional, e.g. 10s)")
+	flagHeaders = headerFlags{}
+	flag.CommandLine.BoolVar(&flagNoCache, "nocache", false, "disable cache of images downloaded from URLs\nand rendered output (optional, cache is in\nuser cache directory)")
+	flag.CommandLine.BoolVar(&flagOffline, "offline", false, "offline mode: only use cached images from URLs")
+	flag.CommandLine.BoolVar(&flagPurge, "purge", false, "purge cache of images downloaded from URLs\nand rendered output (optional, image is not required)")
+	flag.CommandLine.Var(flagHeaders, "H", "extra `header` to download images from URLs\n(optional, \"Name: value\" format, repeatable)")
+}
 
+// flags are parsed by main, not by init, so flags of "go test" are not parsed as pixterm flags
+func parseFlags() {
 	flag.CommandLine.Parse(os.Args[1:])
+
+	// honor NO_COLOR, unless color depth was set by user (info - https://no-color.org)
+	if os.Getenv("NO_COLOR") != "" && !isFlagPassed("c") {
+		flagMono = true
+	}
+}
+
+// terminal detection runs only once, and only if it is needed
+var detectTerminal = sync.OnceValue(func() termdetect.Capabilities {
+	return termdetect.Detect(termdetect.Options{
+		Query: isTerminal() && !flagNoQuery, // only query when user sees the terminal
+	})
+})
+
+func getColorDepth() ansimage.ColorDepth {
+	// user always overrides terminal detection
+	if flagMono {
+		return ansimage.ColorDepthNone
+	}
+	if isFlagPassed("c") {
+		return ansimage.ColorDepth(flagColors)
+	}
+	return ansimage.ColorDepthFromMode(detectTerminal().Best())
+}
+
+func getOutputMode() string {
+	if flagOutput != outputAuto {
+		return flagOutput
+	}
+	if !isTerminal() || flagMono {
+		return outputANSI // graphics protocols only for terminals
+	}
+
+	switch detectTerminal().Best() {
+	case termdetect.ModeSixel:
+		return outputSixel
+	case termdetect.ModeKitty:
+		return outputKitty
+	case termdetect.ModeITerm2:
+		return outputITerm2
+	}
+	return outputANSI
+}
+
+func getCellSize() (width, height int) {
+	if caps := detectTerminal(); caps.CellWidth > 0 && caps.CellHeight > 0 {
+		return caps.CellWidth, caps.CellHeight // queried with capabilities
+	}
+	return 10, 20 // fallback for most common fonts
+}
+
+func isFlagPassed(name string) (passed bool) {
+	flag.CommandLine.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			passed = true
+		}
+	})
+	return
+}
+
+// getSource returns the image source: file, URL or standard input ("-"),
+// which is read without source if it is piped or redirected from a file.
+func getSource() string {
+	if source := flag.CommandLine.Arg(0); source != "" {
+		return source
+	}
+	if info, err := os.Stdin.Stat(); err == nil && (info.Mode()&os.ModeNamedPipe != 0 || info.Mode().IsRegular()) {
+		return ansimage.StdinSource
+	}
+	return ""
 }
 
 func validateFlags() {
@@ -118,7 +277,29 @@ func validateFlags() {
 		os.Exit(0)
 	}
 
-	if flagDither != 0 && flagDither != 1 && flagDither != 2 {
+	if flagColors != 0 && flagColors != 1 && flagColors != 2 && flagColors != 3 {
+		flag.CommandLine.Usage()
+		os.Exit(2)
+	}
+
+	switch flagOutput {
+	case outputAuto, outputANSI, outputSixel, outputKitty, outputITerm2:
+	default:
+		flag.CommandLine.Usage()
+		os.Exit(2)
+	}
+
+	if flagID > math.MaxUint32 {
+		flag.CommandLine.Usage()
+		os.Exit(2)
+	}
+
+	if _, ok := ansimage.LookupRenderer(ansimage.DitheringMode(flagDither)); !ok {
+		flag.CommandLine.Usage()
+		os.Exit(2)
+	}
+
+	if flagDitherA > 9 || flagDitherS < 0 || flagDitherS > 1 {
 		flag.CommandLine.Usage()
 		os.Exit(2)
 	}
@@ -128,36 +309,141 @@ func validateFlags() {
 		os.Exit(2)
 	}
 
+	if flagTimeout < 0 {
+		flag.CommandLine.Usage()
+		os.Exit(2)
+	}
+
+	if flagNoCache && flagOffline {
+		flag.CommandLine.Usage()
+		os.Exit(2)
+	}
+
+	if flagPurge {
+		if err := purgeCache(); err != nil {
+			throwError(1, err)
+		}
+		if getSource() == "" {
+			os.Exit(0)
+		}
+	}
+
+	if flagTol > 255 {
+		flag.CommandLine.Usage()
+		os.Exit(2)
+	}
+
+	if flagThres > 255 {
+		flag.CommandLine.Usage()
+		os.Exit(2)
+	}
+
 	if (flagRows > 0 && flagRows < 2) || (flagCols > 0 && flagCols < 2) {
 		flag.CommandLine.Usage()
 		os.Exit(2)
 	}
 
 	// this is image filename
-	if flag.CommandLine.Arg(0) == "" {
+	if getSource() == "" {
 		flag.CommandLine.Usage()
 		os.Exit(2)
 	}
 }
 
+// output destination of pixterm (standard output, unless user sets another one)
+var pxtOutput io.Writer = os.Stdout
+
+// openOutput opens the output destination: a file, a socket or standard streams.
+func openOutput(dest string) (io.Writer, error) {
+	switch {
+	case dest == "" || dest == "/dev/stdout":
+		return os.Stdout, nil
+	case dest == "/dev/stderr":
+		return os.Stderr, nil
+	case strings.HasPrefix(dest, "tcp://"):
+		return net.Dial("tcp", strings.TrimPrefix(dest, "tcp://"))
+	case strings.HasPrefix(dest, "unix://"):
+		return net.Dial("unix", strings.TrimPrefix(dest, "unix://"))
+	}
+	return os.Create(dest)
+}
+
+// closeOutput closes the output destination, except standard streams.
+func closeOutput() error {
+	if c, ok := pxtOutput.(io.Closer); ok && pxtOutput != os.Stdout && pxtOutput != os.Stderr {
+		return c.Close()
+	}
+	return nil
+}
+
 func isTerminal() bool {
-	return term.IsTerminal(int(os.Stdout.Fd()))
+	f, ok := pxtOutput.(*os.File)
+	return ok && term.IsTerminal(int(f.Fd()))
+}
+
+func clearTerminal() {
+	fmt.Fprint(pxtOutput, "\033[H\033[2J")
 }
 
 func getTerminalSize() (width, height int, err error) {
 	if isTerminal() {
-		return term.GetSize(int(os.Stdout.Fd()))
+		return term.GetSize(int(pxtOutput.(*os.File).Fd()))
 	}
 	// fallback when piping to a file!
 	return 80, 24, nil // VT100 terminal size
 }
 
+func getPalette(name string) (ansimage.Palette, error) {
+	switch strings.ToLower(name) {
+	case "", "xterm":
+		return ansimage.PaletteXterm, nil
+	case "vga":
+		return ansimage.PaletteVGA, nil
+	case "solarized":
+		return ansimage.PaletteSolarized, nil
+	case "tango":
+		return ansimage.PaletteTango, nil
+	}
+	// custom palette with comma-separated hex-colors
+	return ansimage.ParsePalette(strings.Split(name, ",")...)
+}
+
+func getGlyphSet(names string) (ansimage.GlyphSet, error) {
+	if names == "" {
+		return ansimage.GlyphSetDefault, nil
+	}
+
+	var gs ansimage.GlyphSet
+	for _, name := range strings.Split(names, ",") {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "ascii":
+			gs |= ansimage.GlyphSetASCII
+		case "box":
+			gs |= ansimage.GlyphSetBox
+		case "blocks":
+			gs |= ansimage.GlyphSetBlocks
+		case "geometric":
+			gs |= ansimage.GlyphSetGeometric
+		case "all":
+			gs |= ansimage.GlyphSetAll
+		default:
+			return 0, fmt.Errorf("%s is not a glyph set", name)
+		}
+	}
+	return gs, nil
+}
+
 func runPixterm() {
 	var (
 		pix *ansimage.ANSImage
 		err error
 	)
 
+	// open output destination
+	if pxtOutput, err = openOutput(flagOutDest); err != nil {
+		throwError(1, err)
+	}
+
 	// get terminal size
 	tx, ty, err := getTerminalSize()
 	if err != nil {
@@ -166,7 +452,7 @@ func runPixterm() {
 
 	// use custom terminal size (if applies)
 	if ty--; flagRows != 0 { // no custom rows? subtract 1 for prompt spacing
-		ty = int(flagRows) + 1 // weird, but in this case is necessary to add 1 :O
+		ty = int(flagRows)
 	}
 	if flagCols != 0 {
 		tx = int(flagCols)
@@ -179,10 +465,7 @@ func runPixterm() {
 	dm := ansimage.DitheringMode(flagDither)
 
 	// set image scale factor for ANSIPixel grid
-	sfy, sfx := ansimage.BlockSizeY, ansimage.BlockSizeX // 8x4 --> with dithering
-	if ansimage.DitheringMode(flagDither) == ansimage.NoDithering {
-		sfy, sfx = 2, 1 // 2x1 --> without dithering
-	}
+	sfy, sfx := ansimage.CellSize(dm) // 2x1 --> without dithering; 8x4 --> with dithering; 4x2 --> with braille or octants
 
 	// get matte color
 	if flagMatte == "" {
@@ -193,24 +476,262 @@ func runPixterm() {
 		throwError(2, fmt.Sprintf("matte color : %s is not a hex-color", flagMatte))
 	}
 
-	// create new ANSImage from file
-	file := flag.CommandLine.Arg(0)
-	if matched, _ := regexp.MatchString(`^https?://`, file); matched {
-		pix, err = ansimage.NewScaledFromURL(file, sfy*ty, sfx*tx, mc, sm, dm)
-	} else {
-		pix, err = ansimage.NewScaledFromFile(file, sfy*ty, sfx*tx, mc, sm, dm)
+	// draw image with graphics protocol (if applies)
+	file := getSource()
+	if output := getOutputMode(); output != outputANSI {
+		drawGraphics(output, file, ty, tx, mc, sm)
+		if err := closeOutput(); err != nil {
+			throwError(1, err)
+		}
+		return
+	}
+
+	// get color depth from flags or terminal detection
+	cd := getColorDepth()
+
+	// get terminal palette
+	pal, err := getPalette(flagPalette)
+	if err != nil {
+		throwError(2, fmt.Sprintf("palette : %s", err))
+	}
+
+	// get glyph sets for glyph matching
+	gs, err := getGlyphSet(flagGlyphs)
+	if err != nil {
+		throwError(2, fmt.Sprintf("glyphs : %s", err))
+	}
+
+	// read image data from file or URL
+	data, err := readImageData(file)
+	if err != nil {
+		throwError(1, err)
+	}
+
+	// load options of ANSImage
+	loadOpts := ansimage.Options{
+		Height:         sfy * ty,
+		Width:          sfx * tx,
+		ScaleMode:      sm,
+		DitheringMode:  dm,
+		ColorDepth:     cd,
+		Matte:          mc,
+		MaxProcs:       runtime.NumCPU(), // maximum number of parallel goroutines!
+		Palette:        pal,
+		ASCIIOnly:      flagASCII,
+		Threshold:      uint8(flagThres),
+		Dither:         ansimage.Dither(flagDitherA),
+		DitherStrength: &flagDitherS,
+		NoSerpentine:   flagNoSerp,
+	}
+
+	// render options of ANSImage
+	opts := ansimage.RenderOptions{
+		GoCode:         flagGo,
+		DisableBgColor: flagNoBg,
+		Optimize:       true, // minimize escape sequences
+		Tolerance:      uint8(flagTol),
 	}
+
+	// play animated GIF image (if applies, only in terminal)
+	if isTerminal() && !flagGo && bytes.HasPrefix(data, []byte("GIF8")) {
+		anim, err := ansimage.LoadAnimation(context.Background(), ansimage.ReaderSource{Reader: bytes.NewReader(data)}, loadOpts)
+		if err != nil {
+			throwError(1, err)
+		}
+		if len(anim.Delays) > 1 {
+			setupImage(anim.Image, gs)
+			playAnimation(anim, opts)
+			return
+		}
+	}
+
+	// draw cached rendered output (if applies)
+	key := renderCacheKey(file, data, ty, tx, sm, dm, cd, flagMatte)
+	if out, ok := loadRendered(key); ok {
+		if isTerminal() {
+			clearTerminal()
+		}
+		if _, err := pxtOutput.Write(out); err != nil {
+			throwError(1, err)
+		}
+		if flagVerbose {
+			fmt.Fprintf(os.Stderr, "[PIXTERM] output: %d bytes (from cache)\n", len(out))
+		}
+		if isTerminal() {
+			fmt.Fprintln(pxtOutput)
+		}
+		if err := closeOutput(); err != nil {
+			throwError(1, err)
+		}
+		return
+	}
+
+	// create new ANSImage from image data
+	pix, err = ansimage.LoadReader(context.Background(), bytes.NewReader(data), loadOpts)
 	if err != nil {
 		throwError(1, err)
 	}
 
-	// draw ANSImage to terminal
+	// draw ANSImage to output destination
 	if isTerminal() {
-		ansimage.ClearTerminal()
+		clearTerminal()
+	}
+	setupImage(pix, gs)
+	var n int64
+	err = renderCached(key, pxtOutput, func(w io.Writer) (err error) {
+		n, err = pix.RenderTo(w, opts)
+		return err
+	})
+	if err != nil {
+		throwError(1, err)
+	}
+	if flagVerbose {
+		reportSize(pix, opts, n)
 	}
-	pix.SetMaxProcs(runtime.NumCPU()) // maximum number of parallel goroutines!
-	pix.DrawExt(flagGo, flagNoBg)
 	if isTerminal() {
-		fmt.Println()
+		fmt.Fprintln(pxtOutput)
+	}
+	if err := closeOutput(); err != nil {
+		throwError(1, err)
+	}
+}
+
+// setupImage sets the render settings of ANSImage from the flags
+// (settings used to load the image are in its options).
+func setupImage(pix *ansimage.ANSImage, gs ansimage.GlyphSet) {
+	pix.SetInverted(flagInvert)
+	pix.SetGlyphSet(gs)
+}
+
+// playAnimation plays an Animation in terminal until it ends or user interrupts it (e.g. Ctrl-C),
+// restoring the terminal on exit.
+func playAnimation(anim *ansimage.Animation, opts ansimage.RenderOptions) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	clearTerminal()
+	if err := anim.Play(ctx, pxtOutput, opts); err != nil {
+		throwError(1, err)
+	}
+	fmt.Fprintln(pxtOutput)
+	if err := closeOutput(); err != nil {
+		throwError(1, err)
+	}
+}
+
+// reportSize prints the output size and the savings of escape sequence minimization.
+func reportSize(pix *ansimage.ANSImage, opts ansimage.RenderOptions, n int64) {
+	opts.Optimize = false
+	full, _ := pix.RenderTo(io.Discard, opts) // never fails
+	saved := 0.0
+	if full > 0 {
+		saved = 100 * float64(full-n) / float64(full)
+	}
+	fmt.Fprintf(os.Stderr, "[PIXTERM] output: %d bytes (%d bytes without minimization, %.1f%% saved)\n", n, full, saved)
+}
+
+// getFetcher returns the Fetcher to download images from URLs with the flags.
+func getFetcher() *ansimage.Fetcher {
+	return &ansimage.Fetcher{
+		Timeout: flagTimeout,
+		Header:  http.Header(flagHeaders),
+		Retries: ansimage.DefaultFetcher.Retries,
+		Cache:   getCache(),
+	}
+}
+
+func readImageData(file string) ([]byte, error) {
+	return ansimage.ReadSource(context.Background(), file, getFetcher())
+}
+
+// isOpaqueModel reports if images with the color model never have transparency.
+func isOpaqueModel(model color.Model) bool {
+	switch model {
+	case color.YCbCrModel, color.CMYKModel, color.GrayModel, color.Gray16Model, color.RGBAModel, color.RGBA64Model:
+		return true
+	}
+	return false
+}
+
+func drawGraphics(output, file string, ty, tx int, mc color.Color, sm ansimage.ScaleMode) {
+	data, err := readImageData(file)
+	if err != nil {
+		throwError(1, err)
+	}
+
+	if flagNoBg {
+		mc = color.Transparent // transparent pixels are not painted
+	}
+
+	var render string
+	if output == outputITerm2 && sm != ansimage.ScaleModeFill {
+		// pass original image through, terminal scales it (only without transparency or matte)
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			throwError(1, err)
+		}
+		if flagNoBg || isOpaqueModel(config.ColorModel) {
+			render = ansimage.RenderITerm2Data(data, ansimage.ITerm2Options{
+				Name:                filepath.Base(file),
+				Cols:                tx,
+				Rows:                ty,
+				PreserveAspectRatio: sm == ansimage.ScaleModeFit,
+			})
+		}
+	}
+
+	if render == "" {
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			throwError(1, err)
+		}
+
+		// scale image to terminal size in pixels
+		cw, ch := getCellSize()
+		img, err = ansimage.ScaleImage(img, ch*ty, cw*tx, sm)
+		if err != nil {
+			throwError(1, err)
+		}
+
+		// placement size in cells, in case that cell size in pixels is unknown
+		bounds := img.Bounds()
+		cols, rows := (bounds.Dx()+cw/2)/cw, (bounds.Dy()+ch/2)/ch
+
+		switch output {
+		case outputSixel:
+			render = ansimage.RenderSixel(img, mc, ansimage.SixelMaxColors)
+		case outputKitty:
+			render, err = ansimage.RenderKitty(img, mc, ansimage.KittyOptions{
+				ID:   uint32(flagID),
+				Cols: cols,
+				Rows: rows,
+			})
+			if err != nil {
+				throwError(1, err)
+			}
+			if flagDelete {
+				render = ansimage.RenderKittyDelete(uint32(flagID)) + render
+			}
+		case outputITerm2:
+			render = ansimage.RenderITerm2(img, mc, ansimage.ITerm2Options{
+				Name:                filepath.Base(file),
+				Cols:                cols,
+				Rows:                rows,
+				PreserveAspectRatio: true,
+			})
+		}
+	}
+
+	if isTerminal() {
+		clearTerminal()
+	}
+	if flagGo {
+		render = fmt.Sprintf("fmt.Print(%s)\n", strconv.Quote(render))
+	}
+	if isTerminal() {
+		render += "\n"
+	}
+	if _, err := io.WriteString(pxtOutput, render); err != nil {
+		throwError(1, err)
 	}
 }
@@ -0,0 +1,218 @@
+//       ___  _____  ____
+//      / _ \/  _/ |/_/ /____ ______ _
+//     / ___// /_>  </ __/ -_) __/  ' \
+//    /_/  /___/_/|_|\__/\__/_/ /_/_/_/
+//
+//    Copyright 2017 Eliuk Blau
+//
+//    This Source Code Form is subject to the terms of the Mozilla Public
+//    License, v. 2.0. If a copy of the MPL was not distributed with this
+//    file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package ansimage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"io"
+	"time"
+)
+
+// Delays of animation frames: shorter delays than min in GIF images (usually 0 or 10ms)
+// are played at default delay, as web browsers do.
+const (
+	MinFrameDelay     = 20 * time.Millisecond
+	DefaultFrameDelay = 100 * time.Millisecond
+)
+
+// ANSI escape sequences to hide and show cursor while playing animations
+const (
+	hideCursor = "\033[?25l"
+	showCursor = "\033[?25h"
+)
+
+// Animation is a sequence of frames of the same size. Frames of animated GIF images are composed,
+// scaled and converted to its ANSImage one by one while it is played, so only the current frame
+// is in memory, besides the decoded GIF image.
+type Animation struct {
+	Image  *ANSImage       // ANSImage of current frame (its render settings apply to every frame)
+	Delays []time.Duration // delay of each frame before next one (still images have one frame)
+	Loops  int             // times to play animation (0 is forever)
+
+	gif  *gif.GIF // frames of animated GIF image (nil for still images)
+	opts Options  // options to scale frames
+}
+
+// LoadAnimation creates a new Animation from a Source of image data. Animated GIF images
+// are composed frame by frame honoring their disposal methods, delays and loop count,
+// and each frame is scaled to the size in options; other images are a single frame.
+// Animation Image has the first frame until it is played.
+func LoadAnimation(ctx context.Context, src Source, opts Options) (*Animation, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err // before opening source (e.g. downloading image)
+	}
+	reader, err := src.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	br := bufio.NewReader(reader)
+	if magic, _ := br.Peek(4); !bytes.Equal(magic, []byte("GIF8")) {
+		ansimage, err := LoadReader(ctx, br, opts)
+		if err != nil {
+			return nil, err
+		}
+		return &Animation{Image: ansimage, Delays: []time.Duration{0}, Loops: 1}, nil
+	}
+
+	g, err := gif.DecodeAll(br)
+	if err != nil {
+		return nil, err
+	}
+	return loadGIF(ctx, g, opts)
+}
+
+// loadGIF creates a new Animation from the frames of a GIF image, with its first frame in ANSImage.
+func loadGIF(ctx context.Context, g *gif.GIF, opts Options) (*Animation, error) {
+	gc := newGIFComposer(g)
+	ansimage, err := loadImage(ctx, gc.compose(0), opts) // canvas is copied to ANSImage pixels
+	if err != nil {
+		return nil, err
+	}
+
+	anim := &Animation{Image: ansimage, Delays: make([]time.Duration, len(g.Image)), gif: g, opts: opts}
+	for i := range anim.Delays {
+		anim.Delays[i] = DefaultFrameDelay
+		if i < len(g.Delay) && time.Duration(g.Delay[i])*10*time.Millisecond >= MinFrameDelay {
+			anim.Delays[i] = time.Duration(g.Delay[i]) * 10 * time.Millisecond
+		}
+	}
+	switch g.LoopCount {
+	case 0:
+		anim.Loops = 0 // forever
+	case -1:
+		anim.Loops = 1 // no repetitions
+	default:
+		anim.Loops = g.LoopCount + 1
+	}
+	return anim, nil
+}
+
+// gifComposer draws the frames of a GIF image over a canvas, honoring their disposal methods.
+type gifComposer struct {
+	g        *gif.GIF
+	canvas   *image.RGBA
+	previous *image.RGBA // canvas to restore after frames with DisposalPrevious
+}
+
+// newGIFComposer creates a new gifComposer with an empty canvas.
+func newGIFComposer(g *gif.GIF) *gifComposer {
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	for _, frame := range g.Image {
+		bounds = bounds.Union(frame.Bounds()) // some encoders write frames out of logical screen
+	}
+	return &gifComposer{g: g, canvas: image.NewRGBA(bounds)}
+}
+
+// disposal returns the disposal method of frame i.
+func (gc *gifComposer) disposal(i int) byte {
+	if i < len(gc.g.Disposal) {
+		return gc.g.Disposal[i]
+	}
+	return gif.DisposalNone
+}
+
+// compose draws frame i over the canvas, after disposing the previous frame
+// (frames must be composed in order, and first frame clears the canvas). Returns the canvas.
+func (gc *gifComposer) compose(i int) *image.RGBA {
+	if i == 0 {
+		clear(gc.canvas.Pix)
+	} else {
+		switch gc.disposal(i - 1) {
+		case gif.DisposalBackground:
+			draw.Draw(gc.canvas, gc.g.Image[i-1].Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			if gc.previous != nil {
+				copy(gc.canvas.Pix, gc.previous.Pix)
+			}
+		}
+	}
+
+	if gc.disposal(i) == gif.DisposalPrevious {
+		if gc.previous == nil {
+			gc.previous = image.NewRGBA(gc.canvas.Rect)
+		}
+		copy(gc.previous.Pix, gc.canvas.Pix)
+	}
+
+	frame := gc.g.Image[i]
+	draw.Draw(gc.canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over) // transparent pixels keep canvas
+	return gc.canvas
+}
+
+// Play writes the frames of Animation to a terminal, drawing each one over the previous one
+// after its delay, as many times as its loops (or until the context is canceled). Each frame
+// is converted to Animation Image and rendered when it is drawn. Cursor is hidden while playing.
+// Returns a write or conversion error, or nil if context is canceled.
+func (a *Animation) Play(ctx context.Context, w io.Writer, opts RenderOptions) error {
+	if a.Image == nil || len(a.Delays) == 0 {
+		return nil
+	}
+
+	if _, err := io.WriteString(w, hideCursor); err != nil {
+		return err
+	}
+	defer io.WriteString(w, showCursor)
+
+	var (
+		gc  *gifComposer
+		buf *image.RGBA // conversion buffer of frames, reused by ANSImage
+	)
+	if a.gif != nil {
+		gc = newGIFComposer(a.gif)
+	}
+	cy, _ := a.Image.Renderer().CellSize()
+
+	next := time.Now()
+	for loop := 0; a.Loops == 0 || loop < a.Loops; loop++ {
+		for i, delay := range a.Delays {
+			if gc != nil {
+				img, err := scaleImage(gc.compose(i), a.opts)
+				if err != nil {
+					return err
+				}
+				if buf, err = a.Image.setImage(img, buf); err != nil {
+					return err
+				}
+			}
+
+			if loop > 0 || i > 0 {
+				if _, err := fmt.Fprintf(w, "\033[%dA", a.Image.Height()/cy); err != nil { // cursor up to first row
+					return err
+				}
+			}
+			if _, err := a.Image.RenderTo(w, opts); err != nil {
+				return err
+			}
+
+			if len(a.Delays) == 1 {
+				return nil // a still image is drawn only once
+			}
+			next = next.Add(delay)
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return nil
+			}
+		}
+	}
+	return nil
+}
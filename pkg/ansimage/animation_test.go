@@ -0,0 +1,140 @@
+//       ___  _____  ____
+//      / _ \/  _/ |/_/ /____ ______ _
+//     / ___// /_>  </ __/ -_) __/  ' \
+//    /_/  /___/_/|_|\__/\__/_/ /_/_/_/
+//
+//    Copyright 2017 Eliuk Blau
+//
+//    This Source Code Form is subject to the terms of the Mozilla Public
+//    License, v. 2.0. If a copy of the MPL was not distributed with this
+//    file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package ansimage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"testing"
+)
+
+// testGIF returns an animated GIF image with translucent frames of every disposal method.
+func testGIF(loopCount int) *gif.GIF {
+	g := &gif.GIF{LoopCount: loopCount, Config: image.Config{Width: 40, Height: 30}}
+	pal := color.Palette(palette.Plan9)
+	pal[0] = color.Transparent
+	disposals := []byte{gif.DisposalNone, gif.DisposalBackground, gif.DisposalPrevious, gif.DisposalNone, gif.DisposalPrevious}
+	for i, disposal := range disposals {
+		r := image.Rect(i*3, i*2, i*3+25, i*2+20)
+		frame := image.NewPaletted(r, pal)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				if (x+y+i)%5 != 0 { // other pixels are transparent
+					frame.SetColorIndex(x, y, uint8(20+i*30+x))
+				}
+			}
+		}
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 2)
+		g.Disposal = append(g.Disposal, disposal)
+	}
+	return g
+}
+
+func TestAnimationPlay(t *testing.T) {
+	g := testGIF(1) // plays twice
+	for _, opts := range []Options{
+		{Height: 20, Width: 40},
+		{Height: 48, Width: 60, DitheringMode: DitheringWithBlocks, ColorDepth: ColorDepth16, Dither: DitherFloydSteinberg},
+		{Height: 40, Width: 60, DitheringMode: DitheringWithBraille, ColorDepth: ColorDepth256, Matte: color.White},
+	} {
+		anim, err := loadGIF(context.Background(), g, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if anim.Loops != 2 || len(anim.Delays) != len(g.Image) {
+			t.Fatalf("animation has %d loops and %d frames, want 2 and %d", anim.Loops, len(anim.Delays), len(g.Image))
+		}
+
+		// each frame is rendered like a still image of the composed canvas
+		var want bytes.Buffer
+		want.WriteString(hideCursor)
+		gc := newGIFComposer(g)
+		for loop := 0; loop < anim.Loops; loop++ {
+			for i := range g.Image {
+				frame, err := loadImage(context.Background(), gc.compose(i), opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if loop > 0 || i > 0 {
+					cy, _ := frame.Renderer().CellSize()
+					fmt.Fprintf(&want, "\033[%dA", frame.Height()/cy)
+				}
+				frame.RenderTo(&want, RenderOptions{})
+			}
+		}
+		want.WriteString(showCursor)
+
+		var got bytes.Buffer
+		if err := anim.Play(context.Background(), &got, RenderOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Bytes(), want.Bytes()) {
+			t.Errorf("mode %d: played frames differ from still images of composed frames", opts.DitheringMode)
+		}
+	}
+}
+
+func TestGIFComposerDisposal(t *testing.T) {
+	var (
+		none = color.RGBA{}
+		r    = color.RGBA{0xff, 0x00, 0x00, 0xff}
+		g    = color.RGBA{0x00, 0xff, 0x00, 0xff}
+		b    = color.RGBA{0x00, 0x00, 0xff, 0xff}
+		w    = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	)
+	pal := color.Palette{none, r, g, b, w}
+	frame := func(x0 int, pixels ...color.RGBA) *image.Paletted {
+		img := image.NewPaletted(image.Rect(x0, 0, x0+len(pixels), 1), pal)
+		for i, c := range pixels {
+			img.Set(x0+i, 0, c)
+		}
+		return img
+	}
+
+	// canvas of 4x1 pixels, frames over a part of it
+	animated := &gif.GIF{
+		Config: image.Config{Width: 4, Height: 1},
+		Image: []*image.Paletted{
+			frame(0, r, r, r, r),
+			frame(1, g, none),
+			frame(2, b, b),
+			frame(0, none, w),
+			frame(3, g),
+		},
+		Disposal: []byte{gif.DisposalNone, gif.DisposalBackground, gif.DisposalPrevious, gif.DisposalNone, gif.DisposalPrevious},
+	}
+	want := [][]color.RGBA{
+		{r, r, r, r},
+		{r, g, r, r},    // transparent pixel keeps canvas
+		{r, none, b, b}, // frame 1 is cleared to background (transparent) before frame 2 is drawn
+		{r, w, none, r}, // canvas before frame 2 is restored, then frame 3 is drawn
+		{r, w, none, g}, // frame 3 is kept
+		{r, r, r, r},    // next loop clears canvas
+	}
+
+	gc := newGIFComposer(animated)
+	for step, pixels := range want {
+		i := step % len(animated.Image)
+		canvas := gc.compose(i)
+		for x, c := range pixels {
+			if got := canvas.RGBAAt(x, 0); got != c {
+				t.Errorf("frame %d (step %d): pixel %d = %v, want %v", i, step, x, got, c)
+			}
+		}
+	}
+}
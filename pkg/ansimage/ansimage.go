@@ -12,13 +12,16 @@
 package ansimage
 
 import (
+	"bufio"
+	"context"
 	"errors"
 	"fmt"
 
 	"io"
-	"net/http"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"image"
 	"image/color"
@@ -33,13 +36,17 @@ import (
 	_ "golang.org/x/image/webp" // initialize decoder
 
 	"github.com/disintegration/imaging"
-	"github.com/lucasb-eyer/go-colorful"
+	"github.com/eliukblau/pixterm/pkg/termdetect"
 )
 
 // Unicode Block Element character used to represent lower pixel in terminal row.
 // INFO: https://en.wikipedia.org/wiki/Block_Elements
 const lowerHalfBlock = "\u2584"
 
+// Unicode Block Element character used to represent upper pixel in terminal row (without color).
+// INFO: https://en.wikipedia.org/wiki/Block_Elements
+const upperHalfBlock = "\u2580"
+
 // Unicode Block Element characters used to represent dithering in terminal row.
 // INFO: https://en.wikipedia.org/wiki/Block_Elements
 const fullBlock = "\u2588"
@@ -60,11 +67,35 @@ const (
 // ANSImage dithering modes:
 // no dithering (classic mode: half block based),
 // chars (use characters to represent brightness),
-// blocks (use character blocks to represent brightness).
+// blocks (use character blocks to represent brightness),
+// braille (use braille patterns to represent 2x4 pixels per char),
+// quadrants (use block elements to represent 2x2 pixels per char, with 2 colors),
+// sextants (use block sextants to represent 2x3 pixels per char, with 2 colors),
+// octants (use block octants to represent 2x4 pixels per char, with 2 colors),
+// glyphs (use the glyph that best matches the shape of 4x8 pixels per char, with 2 colors).
 const (
 	NoDithering = DitheringMode(iota)
 	DitheringWithBlocks
 	DitheringWithChars
+	DitheringWithBraille
+	DitheringWithQuadrants
+	DitheringWithSextants
+	DitheringWithOctants
+	DitheringWithGlyphs
+)
+
+// ANSImage color depths:
+// true color (24-bit RGB, default),
+// 256 colors (xterm color cube and grayscale ramp),
+// 16 colors (basic and bright colors of the palette),
+// 8 colors (basic colors of the palette),
+// none (plain text without escape sequences, e.g. for NO_COLOR or log files).
+const (
+	ColorDepthTrueColor = ColorDepth(iota)
+	ColorDepth256
+	ColorDepth16
+	ColorDepth8
+	ColorDepthNone
 )
 
 // ANSImage block size in pixels (dithering mode)
@@ -73,24 +104,63 @@ const (
 	BlockSizeX = 4
 )
 
+// ANSImage braille cell size in pixels (braille dithering mode)
+const (
+	BrailleSizeY = 4
+	BrailleSizeX = 2
+)
+
+// ANSImage mosaic cell sizes in pixels (quadrants, sextants and octants dithering modes)
+const (
+	QuadrantSizeY = 2
+	SextantSizeY  = 3
+	OctantSizeY   = 4
+	MosaicSizeX   = 2
+)
+
 var (
-	// ErrImageDownloadFailed occurs in the attempt to download an image and the status code of the response is not "200 OK".
+	// ErrImageDownloadFailed occurs in the attempt to download an image and the status code of the response is not "200 OK"
+	// (wrapped in a *FetchError with the URL and status of the response).
 	ErrImageDownloadFailed = errors.New("ANSImage: image download failed")
 
 	// ErrHeightNonMoT occurs when ANSImage height is not a Multiple of Two value.
 	ErrHeightNonMoT = errors.New("ANSImage: height must be a Multiple of Two value")
 
+	// ErrBoundsNonMoCS occurs when ANSImage height or width are not a Multiple of Cell Size value (braille, mosaic and glyphs dithering modes).
+	ErrBoundsNonMoCS = errors.New("ANSImage: height and width must be multiples of cell size")
+
 	// ErrInvalidBoundsMoT occurs when ANSImage height or width are invalid values (Multiple of Two).
 	ErrInvalidBoundsMoT = errors.New("ANSImage: height or width must be >=2")
 
+	// ErrInvalidPalette occurs when a palette has not exactly 16 valid colors.
+	ErrInvalidPalette = errors.New("ANSImage: palette must have 16 hex-colors")
+
 	// ErrOutOfBounds occurs when ANSI-pixel coordinates are out of ANSImage bounds.
 	ErrOutOfBounds = errors.New("ANSImage: out of bounds")
 
-	// errUnknownScaleMode occurs when scale mode is invalid.
-	errUnknownScaleMode = errors.New("ANSImage: unknown scale mode")
+	// ErrImageTooSmall occurs when an image is smaller than the image pixels of ANSImage.
+	ErrImageTooSmall = errors.New("ANSImage: image is too small")
+
+	// ErrUnknownDitheringMode occurs when dithering mode has no registered Renderer.
+	ErrUnknownDitheringMode = errors.New("ANSImage: unknown dithering mode")
+
+	// ErrUnknownScaleMode occurs when scale mode is invalid.
+	ErrUnknownScaleMode = errors.New("ANSImage: unknown scale mode")
+
+	// ErrUnknownAnchor occurs when anchor point is invalid.
+	ErrUnknownAnchor = errors.New("ANSImage: unknown anchor point")
 
-	// errUnknownDitheringMode occurs when dithering mode is invalid.
-	errUnknownDitheringMode = errors.New("ANSImage: unknown dithering mode")
+	// ErrUnknownFilter occurs when resampling filter is invalid.
+	ErrUnknownFilter = errors.New("ANSImage: unknown resampling filter")
+
+	// ErrUnknownColorDepth occurs when color depth is invalid.
+	ErrUnknownColorDepth = errors.New("ANSImage: unknown color depth")
+
+	// ErrUnknownDither occurs when color dithering algorithm is invalid.
+	ErrUnknownDither = errors.New("ANSImage: unknown color dithering algorithm")
+
+	// ErrUnknownKittyFormat occurs when kitty graphics protocol payload format is invalid.
+	ErrUnknownKittyFormat = errors.New("ANSImage: unknown kitty format")
 )
 
 // ScaleMode type is used for image scale mode constants.
@@ -99,23 +169,47 @@ type ScaleMode uint8
 // DitheringMode type is used for image scale dithering mode constants.
 type DitheringMode uint8
 
+// ColorDepth type is used for image output color depth constants.
+type ColorDepth uint8
+
 // ANSIpixel represents a pixel of an ANSImage.
 type ANSIpixel struct {
 	Brightness uint8
 	R, G, B    uint8
 	upper      bool
+	y, x       int // coordinates in source ANSImage
 	source     *ANSImage
 }
 
+// pixel is the compact form of an ANSI-pixel stored in ANSImage.
+type pixel struct {
+	R, G, B, Brightness uint8
+}
+
 // ANSImage represents an image encoded in ANSI escape codes.
 type ANSImage struct {
-	h, w      int
-	maxprocs  int
-	bgR       uint8
-	bgG       uint8
-	bgB       uint8
-	dithering DitheringMode
-	pixmap    [][]*ANSIpixel
+	h, w           int
+	maxprocs       int
+	bgR            uint8
+	bgG            uint8
+	bgB            uint8
+	bgOpaque       bool // images are composited over background color
+	dithering      DitheringMode
+	renderer       Renderer
+	colordepth     ColorDepth
+	palette        Palette
+	palette16      *colorMatcher
+	palette8       *colorMatcher
+	asciiOnly      bool
+	inverted       bool
+	threshold      uint8
+	glyphset       GlyphSet
+	glyphs         []glyphShape
+	glyphMasks     []uint32
+	dither         Dither
+	ditherStrength float64
+	serpentine     bool
+	pixmap         []pixel // h*w ANSI-pixels, row by row
 }
 
 // Render returns the ANSI-compatible string form of ANSI-pixel.
@@ -132,20 +226,32 @@ func (ap *ANSIpixel) RenderExt(renderGoCode, disableBgColor bool) string {
 		backslash033 = "\\033"
 	}
 
+	// WITHOUT COLOR
+	if ap.source.colordepth == ColorDepthNone {
+		if ap.source.dithering == NoDithering {
+			if ap.upper {
+				return "" // lower pixel renders the whole cell, as ANSImage does
+			}
+			upper := ap.source.pixmap[(ap.y-1)*ap.source.w+ap.x]
+			return ap.source.halfBlocksForBrightness(upper.Brightness, ap.Brightness)
+		}
+		return ap.source.glyphForBrightness(ap.Brightness)
+	}
+
 	// WITHOUT DITHERING
 	if ap.source.dithering == NoDithering {
 		var renderStr string
 		if ap.upper {
 			renderStr = fmt.Sprintf(
-				"%s[48;2;%d;%d;%dm",
+				"%s[%sm",
 				backslash033,
-				ap.R, ap.G, ap.B,
+				ap.source.sgrColor(true, ap.R, ap.G, ap.B),
 			)
 		} else {
 			renderStr = fmt.Sprintf(
-				"%s[38;2;%d;%d;%dm%s",
+				"%s[%sm%s",
 				backslash033,
-				ap.R, ap.G, ap.B,
+				ap.source.sgrColor(false, ap.R, ap.G, ap.B),
 				lowerHalfBlock,
 			)
 		}
@@ -153,58 +259,21 @@ func (ap *ANSIpixel) RenderExt(renderGoCode, disableBgColor bool) string {
 	}
 
 	// WITH DITHERING
-	block := " "
-	if ap.source.dithering == DitheringWithBlocks {
-		switch bri := ap.Brightness; {
-		case bri > 204:
-			block = fullBlock
-		case bri > 152:
-			block = darkShadeBlock
-		case bri > 100:
-			block = mediumShadeBlock
-		case bri > 48:
-			block = lightShadeBlock
-		}
-	} else if ap.source.dithering == DitheringWithChars {
-		switch bri := ap.Brightness; {
-		case bri > 230:
-			block = "#"
-		case bri > 207:
-			block = "&"
-		case bri > 184:
-			block = "$"
-		case bri > 161:
-			block = "X"
-		case bri > 138:
-			block = "x"
-		case bri > 115:
-			block = "="
-		case bri > 92:
-			block = "+"
-		case bri > 69:
-			block = ";"
-		case bri > 46:
-			block = ":"
-		case bri > 23:
-			block = "."
-		}
-	} else {
-		panic(errUnknownDitheringMode)
-	}
+	block := ap.source.glyphForBrightness(ap.Brightness)
 
 	bgColorStr := fmt.Sprintf(
-		"%s[48;2;%d;%d;%dm",
+		"%s[%sm",
 		backslash033,
-		ap.source.bgR, ap.source.bgG, ap.source.bgB,
+		ap.source.sgrColor(true, ap.source.bgR, ap.source.bgG, ap.source.bgB),
 	)
 	if disableBgColor {
 		bgColorStr = ""
 	}
 	return fmt.Sprintf(
-		"%s%s[38;2;%d;%d;%dm%s",
+		"%s%s[%sm%s",
 		bgColorStr,
 		backslash033,
-		ap.R, ap.G, ap.B,
+		ap.source.sgrColor(false, ap.R, ap.G, ap.B),
 		block,
 	)
 }
@@ -224,6 +293,73 @@ func (ai *ANSImage) DitheringMode() DitheringMode {
 	return ai.dithering
 }
 
+// Renderer gets the Renderer of the dithering mode of ANSImage.
+func (ai *ANSImage) Renderer() Renderer {
+	return ai.renderer
+}
+
+// ColorDepth gets the output color depth of ANSImage.
+func (ai *ANSImage) ColorDepth() ColorDepth {
+	return ai.colordepth
+}
+
+// SetColorDepth sets the output color depth of ANSImage.
+// Use a reduced color depth on terminals without true color support.
+// Returns ErrUnknownColorDepth (keeping the current one) if color depth is invalid.
+func (ai *ANSImage) SetColorDepth(cd ColorDepth) error {
+	if cd > ColorDepthNone {
+		return ErrUnknownColorDepth
+	}
+	ai.colordepth = cd
+	return nil
+}
+
+// Palette gets the terminal palette used by ANSImage in 16 and 8 colors depths.
+func (ai *ANSImage) Palette() Palette {
+	return ai.palette
+}
+
+// SetPalette sets the terminal palette used by ANSImage in 16 and 8 colors depths.
+// It should match the colors that the terminal theme actually displays.
+func (ai *ANSImage) SetPalette(p Palette) {
+	ai.palette = p
+	ai.palette16 = newColorMatcher(p[:], 0)
+	ai.palette8 = newColorMatcher(p[:8], 0)
+}
+
+// ASCIIOnly gets if ANSImage renders only 7-bit ASCII characters without color.
+func (ai *ANSImage) ASCIIOnly() bool {
+	return ai.asciiOnly
+}
+
+// SetASCIIOnly sets if ANSImage renders only 7-bit ASCII characters without color
+// (block elements are replaced by the chars brightness ramp).
+func (ai *ANSImage) SetASCIIOnly(asciiOnly bool) {
+	ai.asciiOnly = asciiOnly
+}
+
+// Inverted gets if ANSImage inverts the brightness ramp without color.
+func (ai *ANSImage) Inverted() bool {
+	return ai.inverted
+}
+
+// SetInverted sets if ANSImage inverts the brightness ramp without color
+// (useful for terminals with light background).
+func (ai *ANSImage) SetInverted(inverted bool) {
+	ai.inverted = inverted
+}
+
+// Threshold gets the brightness threshold to light braille dots (0 means adaptive).
+func (ai *ANSImage) Threshold() uint8 {
+	return ai.threshold
+}
+
+// SetThreshold sets the brightness threshold to light braille dots.
+// Zero value means adaptive threshold (mean brightness of each braille cell).
+func (ai *ANSImage) SetThreshold(threshold uint8) {
+	ai.threshold = threshold
+}
+
 // SetMaxProcs sets the maximum number of parallel goroutines to render the ANSImage
 // (user should manually sets `runtime.GOMAXPROCS(max)` before to this change takes effect).
 func (ai *ANSImage) SetMaxProcs(max int) {
@@ -238,11 +374,7 @@ func (ai *ANSImage) GetMaxProcs() int {
 // SetAt sets ANSI-pixel color (RBG) and brightness in coordinates (y,x).
 func (ai *ANSImage) SetAt(y, x int, r, g, b, brightness uint8) error {
 	if y >= 0 && y < ai.h && x >= 0 && x < ai.w {
-		ai.pixmap[y][x].R = r
-		ai.pixmap[y][x].G = g
-		ai.pixmap[y][x].B = b
-		ai.pixmap[y][x].Brightness = brightness
-		ai.pixmap[y][x].upper = ((ai.dithering == NoDithering) && (y%2 == 0))
+		ai.pixmap[y*ai.w+x] = pixel{R: r, G: g, B: b, Brightness: brightness}
 		return nil
 	}
 	return ErrOutOfBounds
@@ -251,19 +383,160 @@ func (ai *ANSImage) SetAt(y, x int, r, g, b, brightness uint8) error {
 // GetAt gets ANSI-pixel in coordinates (y,x).
 func (ai *ANSImage) GetAt(y, x int) (*ANSIpixel, error) {
 	if y >= 0 && y < ai.h && x >= 0 && x < ai.w {
-		return &ANSIpixel{
-				R:          ai.pixmap[y][x].R,
-				G:          ai.pixmap[y][x].G,
-				B:          ai.pixmap[y][x].B,
-				Brightness: ai.pixmap[y][x].Brightness,
-				upper:      ai.pixmap[y][x].upper,
-				source:     ai.pixmap[y][x].source,
-			},
-			nil
+		p := ai.ansiPixel(y, x)
+		return &p, nil
 	}
 	return nil, ErrOutOfBounds
 }
 
+// ansiPixel returns the ANSI-pixel in coordinates (y,x), without bounds checking.
+func (ai *ANSImage) ansiPixel(y, x int) ANSIpixel {
+	p := ai.pixmap[y*ai.w+x]
+	return ANSIpixel{
+		R:          p.R,
+		G:          p.G,
+		B:          p.B,
+		Brightness: p.Brightness,
+		upper:      ((ai.dithering == NoDithering) && (y%2 == 0)),
+		y:          y,
+		x:          x,
+		source:     ai,
+	}
+}
+
+// Reset sets all ANSI-pixels to black color and zero brightness, reusing the pixmap.
+func (ai *ANSImage) Reset() {
+	clear(ai.pixmap)
+}
+
+// SetImage refills the ANSImage with an image.Image, reusing the pixmap (e.g. for animation frames).
+// Image is usually scaled with ScaleImage to the size in pixels of ANSImage, that is its height and
+// width multiplied by the PixelSize of dithering mode Renderer; bigger images are cropped.
+// Background color of ANSImage is used to fill when image has transparency. Pixels are dithered
+// before sampling them with the dithering algorithm of ANSImage (see SetDither).
+func (ai *ANSImage) SetImage(img image.Image) error {
+	_, err := ai.setImage(img, nil)
+	return err
+}
+
+// setImage refills the ANSImage with an image.Image like SetImage, converting it in a buffer
+// that is reused if it has the size in pixels of ANSImage (e.g. between animation frames).
+// Returns the buffer used to convert the image, if any.
+func (ai *ANSImage) setImage(img image.Image, buf *image.RGBA) (*image.RGBA, error) {
+	if !ai.dither.isValid() {
+		return buf, ErrUnknownDither
+	}
+
+	py, px := ai.renderer.PixelSize()
+	bounds := img.Bounds()
+	if bounds.Dy() < ai.h*py || bounds.Dx() < ai.w*px {
+		return buf, ErrImageTooSmall
+	}
+	rect := image.Rect(0, 0, ai.w*px, ai.h*py).Add(bounds.Min) // bigger images are cropped
+
+	// do compositing only if background color has no transparency (thank you @disq for the idea!)
+	// (info - https://stackoverflow.com/questions/36595687/transparent-pixel-color-go-lang-image)
+	rgbaOut, isRGBA := img.(*image.RGBA)
+	dither := ai.dithers()
+	convert := ai.bgOpaque || !isRGBA || dither // dithering quantizes pixels of a copy
+	if convert {
+		if buf == nil || buf.Rect != rect {
+			buf = image.NewRGBA(rect)
+		}
+		rgbaOut = buf
+	}
+	bg := image.NewUniform(color.RGBA{R: ai.bgR, G: ai.bgG, B: ai.bgB, A: 255})
+
+	sample := func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < ai.w; x++ {
+				ai.pixmap[y*ai.w+x] = blockPixel(rgbaOut, rect.Min.Y+py*y, rect.Min.X+px*x, py, px)
+			}
+		}
+	}
+
+	// each goroutine converts its own band of image rows
+	ai.parallelRows(func(y0, y1 int) {
+		if convert {
+			band := image.Rect(rect.Min.X, rect.Min.Y+py*y0, rect.Max.X, rect.Min.Y+py*y1)
+			if ai.bgOpaque {
+				draw.Draw(rgbaOut, band, bg, image.Point{}, draw.Src)
+				draw.Draw(rgbaOut, band, img, band.Min, draw.Over)
+			} else {
+				draw.Draw(rgbaOut, band, img, band.Min, draw.Src)
+			}
+		}
+		if !dither {
+			sample(y0, y1)
+		}
+	})
+
+	// error diffusion goes through the whole image, so pixels are sampled after it
+	if dither {
+		ai.ditherImage(rgbaOut)
+		ai.parallelRows(sample)
+	}
+
+	return buf, nil
+}
+
+// parallelRows calls fn with ranges of ANSI-pixel rows [y0,y1), split in maxprocs goroutines.
+func (ai *ANSImage) parallelRows(fn func(y0, y1 int)) {
+	n := min(max(ai.maxprocs, 1), ai.h)
+	if n == 1 {
+		fn(0, ai.h)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(y0, y1 int) {
+			defer wg.Done()
+			fn(y0, y1)
+		}(ai.h*i/n, ai.h*(i+1)/n)
+	}
+	wg.Wait()
+}
+
+// blockPixel returns the ANSI-pixel of the block of py*px image pixels with upper-left pixel (y,x).
+// Block colors are averaged (without alpha premultiplication), and brightness is the average HSV value.
+func blockPixel(rgba *image.RGBA, y, x, py, px int) pixel {
+	if py == 1 && px == 1 {
+		i := rgba.PixOffset(x, y)
+		r, g, b := rgba.Pix[i], rgba.Pix[i+1], rgba.Pix[i+2]
+		return pixel{R: r, G: g, B: b, Brightness: max(r, g, b)} // same as HSV value
+	}
+
+	var sumR, sumG, sumB, sumBri float64
+	for dy := 0; dy < py; dy++ {
+		i := rgba.PixOffset(x, y+dy)
+		for dx := 0; dx < px; dx++ {
+			c := rgba.Pix[i+4*dx : i+4*dx+4 : i+4*dx+4]
+			a := uint32(c[3]) * 0x101
+			if a == 0 {
+				continue // transparent black
+			}
+			// same as colorful.MakeColor(), but without allocations
+			r := float64(uint32(c[0])*0x101*0xffff/a) / 65535.0
+			g := float64(uint32(c[1])*0x101*0xffff/a) / 65535.0
+			b := float64(uint32(c[2])*0x101*0xffff/a) / 65535.0
+			sumR += r
+			sumG += g
+			sumB += b
+			sumBri += max(r, g, b) // same as HSV value
+		}
+	}
+
+	pixelCount := float64(py * px)
+	return pixel{
+		R:          uint8(sumR/pixelCount*255.0 + 0.5),
+		G:          uint8(sumG/pixelCount*255.0 + 0.5),
+		B:          uint8(sumB/pixelCount*255.0 + 0.5),
+		Brightness: uint8(sumBri/pixelCount*255.0 + 0.5),
+	}
+}
+
 // Render returns the ANSI-compatible string form of ANSImage.
 func (ai *ANSImage) Render() string {
 	return ai.RenderExt(false, false)
@@ -274,74 +547,142 @@ func (ai *ANSImage) Render() string {
 // Can specify if background color will be disabled in dithering mode.
 // (Nice info for ANSI True Colour - https://gist.github.com/XVilka/8346728)
 func (ai *ANSImage) RenderExt(renderGoCode, disableBgColor bool) string {
+	var sb strings.Builder
+	ai.RenderTo(&sb, RenderOptions{GoCode: renderGoCode, DisableBgColor: disableBgColor}) // never fails writing to memory
+	return sb.String()
+}
+
+// RenderOptions are the options to render an ANSImage.
+type RenderOptions struct {
+	GoCode         bool  // renders in form of Go code 'fmt.Printf()'
+	DisableBgColor bool  // disables background color in dithering mode
+	Optimize       bool  // minimizes escape sequences, skipping colors already set by previous cells
+	Tolerance      uint8 // max difference per color component to reuse current colors (only optimizing)
+}
+
+// RenderTo writes the ANSI-compatible form of ANSImage to a writer, streaming it row by row
+// through a buffer instead of building the whole string in memory. Returns the number of
+// bytes written and any write error.
+func (ai *ANSImage) RenderTo(w io.Writer, opts RenderOptions) (int64, error) {
+	rc := &RenderContext{
+		Image:          ai,
+		GoCode:         opts.GoCode,
+		DisableBgColor: opts.DisableBgColor,
+		Optimize:       opts.Optimize,
+		Tolerance:      opts.Tolerance,
+	}
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+
+	err := ai.renderRows(rc, func(row string) error {
+		_, err := bw.WriteString(row)
+		return err
+	})
+	if err == nil {
+		err = bw.Flush()
+	}
+	return cw.n, err
+}
+
+// renderRows renders every row of terminal cells with a bounded pool of maxprocs workers,
+// and writes them in order as soon as they are rendered (output is the same for any maxprocs).
+// It stops at first write error.
+func (ai *ANSImage) renderRows(rc *RenderContext, write func(row string) error) error {
+	cy, cx := ai.renderer.CellSize()
+	rows := ai.h / cy
+
+	workers := min(ai.maxprocs, rows)
+	if workers <= 1 { // sequential render
+		for r := 0; r < rows; r++ {
+			if err := write(ai.renderRow(rc, r, cy, cx)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
 	type renderData struct {
 		row    int
 		render string
 	}
 
-	backslashN := "\n"
-	backslash033 := "\033"
-	if renderGoCode {
-		backslashN = "\\n"
-		backslash033 = "\\033"
-	}
-
-	// WITHOUT DITHERING
-	if ai.dithering == NoDithering {
-		rows := make([]string, ai.h/2)
-		for y := 0; y < ai.h; y += ai.maxprocs {
-			ch := make(chan renderData, ai.maxprocs)
-			for n, r := 0, y+1; (n <= ai.maxprocs) && (2*r+1 < ai.h); n, r = n+1, y+n+1 {
-				go func(r, y int) {
-					var str string
-					for x := 0; x < ai.w; x++ {
-						str += ai.pixmap[y][x].RenderExt(renderGoCode, disableBgColor)   // upper pixel
-						str += ai.pixmap[y+1][x].RenderExt(renderGoCode, disableBgColor) // lower pixel
-					}
-					str += fmt.Sprintf("%s[0m%s", backslash033, backslashN) // reset ansi style
-					ch <- renderData{row: r, render: str}
-				}(r, 2*r)
-				// DEBUG:
-				// fmt.Printf("y:%d | n:%d | r:%d | 2*r:%d\n", y, n, r, 2*r)
-				// time.Sleep(time.Millisecond * 100)
-			}
-			for n, r := 0, y+1; (n <= ai.maxprocs) && (2*r+1 < ai.h); n, r = n+1, y+n+1 {
-				data := <-ch
-				if renderGoCode {
-					data.render = fmt.Sprintf(`fmt.Print("%s")%s`, data.render, "\n")
+	// workers take rows in order, and each row holds a token until it is written,
+	// so rows rendered ahead of the written ones are bounded by window size
+	window := 2 * workers
+	tokens := make(chan struct{}, window)
+	results := make(chan renderData, window) // never blocks workers
+	done := make(chan struct{})
+
+	// workers are finished before return, so image is not rendered after it
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer close(done)
+
+	var next atomic.Int64
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case tokens <- struct{}{}:
+				case <-done:
+					return
 				}
-				rows[data.row] = data.render
-				// DEBUG:
-				// fmt.Printf("data.row:%d\n", data.row)
-				// time.Sleep(time.Millisecond * 100)
+				r := int(next.Add(1) - 1)
+				if r >= rows {
+					return
+				}
+				results <- renderData{row: r, render: ai.renderRow(rc, r, cy, cx)}
 			}
-		}
-		return strings.Join(rows, "")
+		}()
 	}
 
-	// WITH DITHERING
-	rows := make([]string, ai.h)
-	for y := 0; y < ai.h; y += ai.maxprocs {
-		ch := make(chan renderData, ai.maxprocs)
-		for n, r := 0, y; (n <= ai.maxprocs) && (r+1 < ai.h); n, r = n+1, y+n+1 {
-			go func(y int) {
-				var str string
-				for x := 0; x < ai.w; x++ {
-					str += ai.pixmap[y][x].RenderExt(renderGoCode, disableBgColor)
-				}
-				str += fmt.Sprintf("%s[0m%s", backslash033, backslashN) // reset ansi style
-				ch <- renderData{row: y, render: str}
-			}(r)
-		}
-		for n, r := 0, y; (n <= ai.maxprocs) && (r+1 < ai.h); n, r = n+1, y+n+1 {
-			data := <-ch
-			if renderGoCode {
-				data.render = fmt.Sprintf(`fmt.Print("%s")%s`, data.render, "\n")
+	pending := make(map[int]string, window)
+	for written := 0; written < rows; {
+		data := <-results
+		pending[data.row] = data.render
+		for render, ok := pending[written]; ok; render, ok = pending[written] {
+			delete(pending, written)
+			if err := write(render); err != nil {
+				return err
 			}
-			rows[data.row] = data.render
+			<-tokens
+			written++
 		}
 	}
-	return strings.Join(rows, "")
+	return nil
+}
+
+// renderRow returns the ANSI-compatible string form of a row of terminal cells.
+func (ai *ANSImage) renderRow(rc *RenderContext, r, cy, cx int) string {
+	row := *rc // each row tracks its own colors
+	rc = &row
+
+	var sb strings.Builder
+	if rc.GoCode {
+		sb.WriteString(`fmt.Print("`)
+	}
+	for c := 0; c < ai.w/cx; c++ {
+		sb.WriteString(ai.renderer.EncodeCell(rc, Cell{Row: r, Col: c, image: ai, y: r * cy, x: c * cx}))
+	}
+	sb.WriteString(ai.renderer.RowEnd(rc))
+	if rc.GoCode {
+		sb.WriteString("\")\n")
+	}
+	return sb.String()
+}
+
+// countWriter counts the bytes written to a writer.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
 }
 
 // Draw writes the ANSImage to standard output (terminal).
@@ -353,47 +694,159 @@ func (ai *ANSImage) Draw() {
 // Can specify if it prints in form of Go code 'fmt.Printf()'.
 // Can specify if background color will be disabled in dithering mode.
 func (ai *ANSImage) DrawExt(renderGoCode, disableBgColor bool) {
-	fmt.Print(ai.RenderExt(renderGoCode, disableBgColor))
+	ai.RenderTo(os.Stdout, RenderOptions{GoCode: renderGoCode, DisableBgColor: disableBgColor})
+}
+
+// DrawTo writes the ANSImage to a writer (e.g. a file, a socket or standard error),
+// returning the number of bytes written and any write error.
+func (ai *ANSImage) DrawTo(w io.Writer) (int64, error) {
+	return ai.RenderTo(w, RenderOptions{})
+}
+
+// sgrColor returns the SGR parameters to set the foreground or background color (r,g,b),
+// according to the color depth of ANSImage.
+func (ai *ANSImage) sgrColor(background bool, r, g, b uint8) string {
+	code := 38 // foreground
+	if background {
+		code = 48
+	}
+
+	switch ai.colordepth {
+	case ColorDepthTrueColor:
+		return fmt.Sprintf("%d;2;%d;%d;%d", code, r, g, b)
+	case ColorDepth256:
+		return fmt.Sprintf("%d;5;%d", code, xterm256.index(r, g, b))
+	case ColorDepth16:
+		i := ai.palette16.index(r, g, b)
+		if i >= 8 {
+			return fmt.Sprintf("%d", code+52+i-8) // bright colors: 90-97 & 100-107
+		}
+		return fmt.Sprintf("%d", code-8+i) // basic colors: 30-37 & 40-47
+	case ColorDepth8:
+		return fmt.Sprintf("%d", code-8+ai.palette8.index(r, g, b))
+	default:
+		panic(ErrUnknownColorDepth) // checked by SetColorDepth
+	}
+}
+
+// glyphForBrightness returns the block element or char that represents the brightness,
+// according to the dithering mode of ANSImage. Without color, the brightness ramp
+// is inverted or restricted to ASCII chars if ANSImage is configured for it.
+func (ai *ANSImage) glyphForBrightness(bri uint8) string {
+	if ai.colordepth == ColorDepthNone && ai.inverted {
+		bri = 255 - bri
+	}
+
+	dm := ai.dithering
+	if ai.colordepth == ColorDepthNone && (dm == NoDithering || ai.asciiOnly) {
+		dm = DitheringWithChars // no block elements without color or out of ASCII
+	}
+
+	switch dm {
+	case DitheringWithChars:
+		switch {
+		case bri > 230:
+			return "#"
+		case bri > 207:
+			return "&"
+		case bri > 184:
+			return "$"
+		case bri > 161:
+			return "X"
+		case bri > 138:
+			return "x"
+		case bri > 115:
+			return "="
+		case bri > 92:
+			return "+"
+		case bri > 69:
+			return ";"
+		case bri > 46:
+			return ":"
+		case bri > 23:
+			return "."
+		}
+	case DitheringWithBraille:
+		if bri > ai.fixedThreshold() {
+			return string(rune(brailleBase + 0xff)) // all dots are lit
+		}
+	case DitheringWithQuadrants, DitheringWithSextants, DitheringWithOctants, DitheringWithGlyphs:
+		if bri > ai.fixedThreshold() {
+			return fullBlock // all sub-cells are set
+		}
+	default: // blocks ramp, also for dithering modes out of this package
+		switch {
+		case bri > 204:
+			return fullBlock
+		case bri > 152:
+			return darkShadeBlock
+		case bri > 100:
+			return mediumShadeBlock
+		case bri > 48:
+			return lightShadeBlock
+		}
+	}
+	return " "
+}
+
+// halfBlocksForBrightness returns the half block elements that represent the brightness
+// of an upper and a lower pixel in the same terminal cell (without color).
+func (ai *ANSImage) halfBlocksForBrightness(upperBri, lowerBri uint8) string {
+	if ai.asciiOnly {
+		return ai.glyphForBrightness(uint8((int(upperBri) + int(lowerBri) + 1) / 2))
+	}
+
+	upperLit, lowerLit := upperBri > 127, lowerBri > 127
+	if ai.inverted {
+		upperLit, lowerLit = !upperLit, !lowerLit
+	}
+
+	switch {
+	case upperLit && lowerLit:
+		return fullBlock
+	case upperLit:
+		return upperHalfBlock
+	case lowerLit:
+		return lowerHalfBlock
+	}
+	return " "
 }
 
 // New creates a new empty ANSImage ready to draw on it.
 func New(h, w int, bg color.Color, dm DitheringMode) (*ANSImage, error) {
-	if (dm == NoDithering) && (h%2 != 0) {
-		return nil, ErrHeightNonMoT
+	renderer, ok := LookupRenderer(dm)
+	if !ok {
+		return nil, ErrUnknownDitheringMode
+	}
+
+	if cy, cx := renderer.CellSize(); h%cy != 0 || w%cx != 0 {
+		if dm == NoDithering {
+			return nil, ErrHeightNonMoT
+		}
+		return nil, ErrBoundsNonMoCS
 	}
 
 	if h < 2 || w < 2 {
 		return nil, ErrInvalidBoundsMoT
 	}
 
-	r, g, b, _ := bg.RGBA()
+	r, g, b, a := bg.RGBA()
 	ansimage := &ANSImage{
 		h: h, w: w,
-		maxprocs:  1,
-		bgR:       uint8(r),
-		bgG:       uint8(g),
-		bgB:       uint8(b),
-		dithering: dm,
-		pixmap:    nil,
-	}
-
-	ansimage.pixmap = func() [][]*ANSIpixel {
-		v := make([][]*ANSIpixel, h)
-		for y := 0; y < h; y++ {
-			v[y] = make([]*ANSIpixel, w)
-			for x := 0; x < w; x++ {
-				v[y][x] = &ANSIpixel{
-					R:          0,
-					G:          0,
-					B:          0,
-					Brightness: 0,
-					source:     ansimage,
-					upper:      ((dm == NoDithering) && (y%2 == 0)),
-				}
-			}
-		}
-		return v
-	}()
+		maxprocs:       1,
+		bgR:            uint8(r),
+		bgG:            uint8(g),
+		bgB:            uint8(b),
+		bgOpaque:       a >= 0xffff,
+		dithering:      dm,
+		renderer:       renderer,
+		colordepth:     ColorDepthTrueColor,
+		ditherStrength: 1,
+		serpentine:     true,
+		pixmap:         make([]pixel, h*w),
+	}
+	ansimage.SetPalette(PaletteXterm)
+	ansimage.SetGlyphSet(GlyphSetDefault)
 
 	return ansimage, nil
 }
@@ -402,114 +855,102 @@ func New(h, w int, bg color.Color, dm DitheringMode) (*ANSImage, error) {
 // Background color is used to fill when image has transparency or dithering mode is enabled.
 // Dithering mode is used to specify the way that ANSImage render ANSI-pixels (char/block elements).
 func NewFromImage(image image.Image, bg color.Color, dm DitheringMode) (*ANSImage, error) {
-	return createANSImage(image, bg, dm)
+	return loadImage(context.Background(), image, Options{Matte: bg, DitheringMode: dm})
 }
 
 // NewScaledFromImage creates a new scaled ANSImage from an image.Image.
 // Background color is used to fill when image has transparency or dithering mode is enabled.
 // Dithering mode is used to specify the way that ANSImage render ANSI-pixels (char/block elements).
 func NewScaledFromImage(image image.Image, y, x int, bg color.Color, sm ScaleMode, dm DitheringMode) (*ANSImage, error) {
-	switch sm {
-	case ScaleModeResize:
-		image = imaging.Resize(image, x, y, imaging.Lanczos)
-	case ScaleModeFill:
-		image = imaging.Fill(image, x, y, imaging.Center, imaging.Lanczos)
-	case ScaleModeFit:
-		image = imaging.Fit(image, x, y, imaging.Lanczos)
-	default:
-		panic(errUnknownScaleMode)
-	}
-
-	return createANSImage(image, bg, dm)
+	return loadImage(context.Background(), image, scaledOptions(y, x, bg, sm, dm))
 }
 
 // NewFromReader creates a new ANSImage from an io.Reader.
 // Background color is used to fill when image has transparency or dithering mode is enabled.
 // Dithering mode is used to specify the way that ANSImage render ANSI-pixels (char/block elements).
 func NewFromReader(reader io.Reader, bg color.Color, dm DitheringMode) (*ANSImage, error) {
-	image, _, err := image.Decode(reader)
-	if err != nil {
-		return nil, err
-	}
-
-	return createANSImage(image, bg, dm)
+	return LoadSource(context.Background(), ReaderSource{Reader: reader}, Options{Matte: bg, DitheringMode: dm})
 }
 
 // NewScaledFromReader creates a new scaled ANSImage from an io.Reader.
 // Background color is used to fill when image has transparency or dithering mode is enabled.
 // Dithering mode is used to specify the way that ANSImage render ANSI-pixels (char/block elements).
 func NewScaledFromReader(reader io.Reader, y, x int, bg color.Color, sm ScaleMode, dm DitheringMode) (*ANSImage, error) {
-	image, _, err := image.Decode(reader)
-	if err != nil {
-		return nil, err
-	}
-
-	switch sm {
-	case ScaleModeResize:
-		image = imaging.Resize(image, x, y, imaging.Lanczos)
-	case ScaleModeFill:
-		image = imaging.Fill(image, x, y, imaging.Center, imaging.Lanczos)
-	case ScaleModeFit:
-		image = imaging.Fit(image, x, y, imaging.Lanczos)
-	default:
-		panic(errUnknownScaleMode)
-	}
+	return LoadSource(context.Background(), ReaderSource{Reader: reader}, scaledOptions(y, x, bg, sm, dm))
+}
 
-	return createANSImage(image, bg, dm)
+// NewFromSource creates a new ANSImage from a Source of image data (e.g. FSSource or ArchiveSource).
+// Background color is used to fill when image has transparency or dithering mode is enabled.
+// Dithering mode is used to specify the way that ANSImage render ANSI-pixels (char/block elements).
+func NewFromSource(src Source, bg color.Color, dm DitheringMode) (*ANSImage, error) {
+	return LoadSource(context.Background(), src, Options{Matte: bg, DitheringMode: dm})
+}
+
+// NewScaledFromSource creates a new scaled ANSImage from a Source of image data (e.g. FSSource or ArchiveSource).
+// Background color is used to fill when image has transparency or dithering mode is enabled.
+// Dithering mode is used to specify the way that ANSImage render ANSI-pixels (char/block elements).
+func NewScaledFromSource(src Source, y, x int, bg color.Color, sm ScaleMode, dm DitheringMode) (*ANSImage, error) {
+	return LoadSource(context.Background(), src, scaledOptions(y, x, bg, sm, dm))
 }
 
 // NewFromFile creates a new ANSImage from a file.
 // Background color is used to fill when image has transparency or dithering mode is enabled.
 // Dithering mode is used to specify the way that ANSImage render ANSI-pixels (char/block elements).
 func NewFromFile(name string, bg color.Color, dm DitheringMode) (*ANSImage, error) {
-	reader, err := os.Open(name)
-	if err != nil {
-		return nil, err
-	}
-	defer reader.Close()
-	return NewFromReader(reader, bg, dm)
+	return LoadSource(context.Background(), FileSource(name), Options{Matte: bg, DitheringMode: dm})
 }
 
 // NewScaledFromFile creates a new scaled ANSImage from a file.
 // Background color is used to fill when image has transparency or dithering mode is enabled.
 // Dithering mode is used to specify the way that ANSImage render ANSI-pixels (char/block elements).
 func NewScaledFromFile(name string, y, x int, bg color.Color, sm ScaleMode, dm DitheringMode) (*ANSImage, error) {
-	reader, err := os.Open(name)
-	if err != nil {
-		return nil, err
-	}
-	defer reader.Close()
-	return NewScaledFromReader(reader, y, x, bg, sm, dm)
+	return LoadSource(context.Background(), FileSource(name), scaledOptions(y, x, bg, sm, dm))
 }
 
 // NewFromURL creates a new ANSImage from an image URL.
 // Background color is used to fill when image has transparency or dithering mode is enabled.
 // Dithering mode is used to specify the way that ANSImage render ANSI-pixels (char/block elements).
 func NewFromURL(url string, bg color.Color, dm DitheringMode) (*ANSImage, error) {
-	res, err := http.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	if res.StatusCode != http.StatusOK {
-		return nil, ErrImageDownloadFailed
-	}
-	defer res.Body.Close()
-	return NewFromReader(res.Body, bg, dm)
+	return LoadSource(context.Background(), URLSource{URL: url}, Options{Matte: bg, DitheringMode: dm})
 }
 
 // NewScaledFromURL creates a new scaled ANSImage from an image URL.
 // Background color is used to fill when image has transparency or dithering mode is enabled.
 // Dithering mode is used to specify the way that ANSImage render ANSI-pixels (char/block elements).
 func NewScaledFromURL(url string, y, x int, bg color.Color, sm ScaleMode, dm DitheringMode) (*ANSImage, error) {
-	res, err := http.Get(url)
-	if err != nil {
-		return nil, err
+	return LoadSource(context.Background(), URLSource{URL: url}, scaledOptions(y, x, bg, sm, dm))
+}
+
+// CellSize returns the size in pixels of the image area represented by each terminal cell (char)
+// in the dithering mode. It is used to scale images to terminal size.
+func CellSize(dm DitheringMode) (y, x int) {
+	r, ok := LookupRenderer(dm)
+	if !ok {
+		return BlockSizeY, BlockSizeX
 	}
-	if res.StatusCode != http.StatusOK {
-		return nil, ErrImageDownloadFailed
+	py, px := r.PixelSize()
+	cy, cx := r.CellSize()
+	return py * cy, px * cx
+}
+
+// ScaleImage returns a scaled copy of an image.Image to (y,x) pixels, using the scale mode.
+// It is used by scaled ANSImage constructors, and to scale images for graphics protocols.
+func ScaleImage(img image.Image, y, x int, sm ScaleMode) (image.Image, error) {
+	return scaleImage(img, Options{Height: y, Width: x, ScaleMode: sm})
+}
+
+// ColorDepthFromMode returns the best ANSImage color depth for a terminal output mode
+// detected with termdetect package (graphics protocols also support true color).
+func ColorDepthFromMode(m termdetect.Mode) ColorDepth {
+	switch m {
+	case termdetect.ModeNone:
+		return ColorDepthNone
+	case termdetect.Mode16:
+		return ColorDepth16
+	case termdetect.Mode256:
+		return ColorDepth256
 	}
-	defer res.Body.Close()
-	return NewScaledFromReader(res.Body, y, x, bg, sm, dm)
+	return ColorDepthTrueColor
 }
 
 // ClearTerminal clears current terminal buffer using ANSI escape code.
@@ -518,87 +959,12 @@ func ClearTerminal() {
 	fmt.Print("\033[H\033[2J")
 }
 
-// createANSImage loads data from an image and returns an ANSImage.
-// Background color is used to fill when image has transparency or dithering mode is enabled.
-// Dithering mode is used to specify the way that ANSImage render ANSI-pixels (char/block elements).
-func createANSImage(img image.Image, bg color.Color, dm DitheringMode) (*ANSImage, error) {
-	var rgbaOut *image.RGBA
-	bounds := img.Bounds()
-
-	// do compositing only if background color has no transparency (thank you @disq for the idea!)
-	// (info - https://stackoverflow.com/questions/36595687/transparent-pixel-color-go-lang-image)
+// compositeImage returns a copy of an image.Image with origin (0,0), composited over the
+// background color only if it has no transparency (same as SetImage does).
+func compositeImage(img image.Image, bg color.Color) *image.NRGBA {
 	if _, _, _, a := bg.RGBA(); a >= 0xffff {
-		rgbaOut = image.NewRGBA(bounds)
-		draw.Draw(rgbaOut, bounds, image.NewUniform(bg), image.Point{}, draw.Src)
-		draw.Draw(rgbaOut, bounds, img, image.Point{}, draw.Over)
-	} else {
-		if v, ok := img.(*image.RGBA); ok {
-			rgbaOut = v
-		} else {
-			rgbaOut = image.NewRGBA(bounds)
-			draw.Draw(rgbaOut, bounds, img, image.Point{}, draw.Src)
-		}
-	}
-
-	yMin, xMin := bounds.Min.Y, bounds.Min.X
-	yMax, xMax := bounds.Max.Y, bounds.Max.X
-
-	if dm == NoDithering {
-		// always sets an even number of ANSIPixel rows...
-		yMax = yMax - yMax%2 // one for upper pixel and another for lower pixel --> without dithering
-	} else {
-		yMax = yMax / BlockSizeY // always sets 1 ANSIPixel block...
-		xMax = xMax / BlockSizeX // per 8x4 real pixels --> with dithering
+		bounds := img.Bounds()
+		return imaging.Overlay(imaging.New(bounds.Dx(), bounds.Dy(), bg), img, image.Point{}, 1.0)
 	}
-
-	ansimage, err := New(yMax, xMax, bg, dm)
-	if err != nil {
-		return nil, err
-	}
-
-	if dm == NoDithering {
-		for y := yMin; y < yMax; y++ {
-			for x := xMin; x < xMax; x++ {
-				v := rgbaOut.RGBAAt(x, y)
-				if err := ansimage.SetAt(y, x, v.R, v.G, v.B, 0); err != nil {
-					return nil, err
-				}
-			}
-		}
-	} else {
-		pixelCount := BlockSizeY * BlockSizeX
-
-		for y := yMin; y < yMax; y++ {
-			for x := xMin; x < xMax; x++ {
-
-				var sumR, sumG, sumB, sumBri float64
-				for dy := 0; dy < BlockSizeY; dy++ {
-					py := BlockSizeY*y + dy
-
-					for dx := 0; dx < BlockSizeX; dx++ {
-						px := BlockSizeX*x + dx
-
-						pixel := rgbaOut.At(px, py)
-						color, _ := colorful.MakeColor(pixel)
-						_, _, v := color.Hsv()
-						sumR += color.R
-						sumG += color.G
-						sumB += color.B
-						sumBri += v
-					}
-				}
-
-				r := uint8(sumR/float64(pixelCount)*255.0 + 0.5)
-				g := uint8(sumG/float64(pixelCount)*255.0 + 0.5)
-				b := uint8(sumB/float64(pixelCount)*255.0 + 0.5)
-				brightness := uint8(sumBri/float64(pixelCount)*255.0 + 0.5)
-
-				if err := ansimage.SetAt(y, x, r, g, b, brightness); err != nil {
-					return nil, err
-				}
-			}
-		}
-	}
-
-	return ansimage, nil
+	return imaging.Clone(img)
 }
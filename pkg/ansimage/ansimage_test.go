@@ -0,0 +1,371 @@
+//       ___  _____  ____
+//      / _ \/  _/ |/_/ /____ ______ _
+//     / ___// /_>  </ __/ -_) __/  ' \
+//    /_/  /___/_/|_|\__/\__/_/ /_/_/_/
+//
+//    Copyright 2017 Eliuk Blau
+//
+//    This Source Code Form is subject to the terms of the Mozilla Public
+//    License, v. 2.0. If a copy of the MPL was not distributed with this
+//    file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package ansimage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lucasb-eyer/go-colorful"
+)
+
+// testImage returns an image with color gradients and a checkerboard, so every
+// dithering mode renders different glyphs and colors.
+func testImage(h, w int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{R: uint8(x * 255 / w), G: uint8(y * 255 / h), B: uint8((x + y) * 7), A: 255}
+			if (x/5+y/3)%4 == 0 {
+				c.R, c.G, c.B = 255-c.R, 255-c.G, 255-c.B
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// registeredModes returns the dithering modes registered in renderers.
+func registeredModes() []DitheringMode {
+	renderersMu.RLock()
+	defer renderersMu.RUnlock()
+	var modes []DitheringMode
+	for dm := range renderers {
+		modes = append(modes, dm)
+	}
+	slices.Sort(modes)
+	return modes
+}
+
+// loadTestImage loads a test image scaled to rows*cols terminal cells.
+func loadTestImage(tb testing.TB, rows, cols int, opts Options) *ANSImage {
+	tb.Helper()
+	sy, sx := CellSize(opts.DitheringMode)
+	ai, err := loadImage(context.Background(), testImage(rows*sy, cols*sx), opts)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return ai
+}
+
+func TestRenderToMaxProcs(t *testing.T) {
+	const rows, cols = 7, 9 // odd rows, so workers render different counts of rows
+
+	for _, dm := range registeredModes() {
+		for cd := ColorDepthTrueColor; cd <= ColorDepthNone; cd++ {
+			for d := NoDither; d <= DitherBlueNoise; d++ {
+				t.Run(fmt.Sprintf("mode%d/depth%d/dither%d", dm, cd, d), func(t *testing.T) {
+					ai := loadTestImage(t, rows, cols, Options{DitheringMode: dm, ColorDepth: cd, Dither: d})
+					for _, opts := range []RenderOptions{{}, {Optimize: true, Tolerance: 8}, {GoCode: true, DisableBgColor: true}} {
+						ai.SetMaxProcs(1)
+						var want bytes.Buffer
+						if _, err := ai.RenderTo(&want, opts); err != nil {
+							t.Fatal(err)
+						}
+						if lines := bytes.Count(want.Bytes(), []byte("\n")); lines != rows {
+							t.Fatalf("%+v: rendered %d rows, want %d", opts, lines, rows) // every row, including first and last
+						}
+
+						for _, procs := range []int{2, 3, rows + 5} {
+							ai.SetMaxProcs(procs)
+							var got bytes.Buffer
+							n, err := ai.RenderTo(&got, opts)
+							if err != nil {
+								t.Fatal(err)
+							}
+							if n != int64(got.Len()) {
+								t.Errorf("%+v, maxprocs %d: RenderTo returned %d bytes, wrote %d", opts, procs, n, got.Len())
+							}
+							if !bytes.Equal(got.Bytes(), want.Bytes()) {
+								t.Errorf("%+v, maxprocs %d: output differs from sequential render", opts, procs)
+							}
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestInvalidSettings(t *testing.T) {
+	ai := loadTestImage(t, 2, 2, Options{ColorDepth: ColorDepth256})
+	if err := ai.SetColorDepth(ColorDepthNone + 1); !errors.Is(err, ErrUnknownColorDepth) {
+		t.Errorf("SetColorDepth error = %v, want %v", err, ErrUnknownColorDepth)
+	}
+	if cd := ai.ColorDepth(); cd != ColorDepth256 {
+		t.Errorf("SetColorDepth changed color depth to %d", cd)
+	}
+	if p, _ := ai.GetAt(1, 1); p.Render() == "" {
+		t.Error("ANSI-pixel renders nothing")
+	}
+
+	if _, err := RenderKitty(testImage(2, 2), color.Black, KittyOptions{Format: KittyFormatRGBA + 1}); !errors.Is(err, ErrUnknownKittyFormat) {
+		t.Errorf("RenderKitty error = %v, want %v", err, ErrUnknownKittyFormat)
+	}
+}
+
+func TestMonoPixelsRenderLikeImage(t *testing.T) {
+	for _, dm := range []DitheringMode{NoDithering, DitheringWithBlocks, DitheringWithChars} {
+		for _, asciiOnly := range []bool{false, true} {
+			ai := loadTestImage(t, 6, 12, Options{DitheringMode: dm, ColorDepth: ColorDepthNone, ASCIIOnly: asciiOnly})
+			ai.SetInverted(asciiOnly) // both settings apply to ANSI-pixels too
+
+			// each cell is rendered by its pixels, row by row, and upper pixels render nothing
+			var want strings.Builder
+			for y := 0; y < ai.Height(); y++ {
+				for x := 0; x < ai.Width(); x++ {
+					p, _ := ai.GetAt(y, x)
+					want.WriteString(p.Render())
+				}
+				if cy, _ := ai.Renderer().CellSize(); y%cy == cy-1 {
+					want.WriteString("\n")
+				}
+			}
+			if got := ai.Render(); got != want.String() {
+				t.Errorf("mode %d, ASCII only %v: ANSImage renders\n%s\nANSI-pixels render\n%s", dm, asciiOnly, got, want.String())
+			}
+		}
+	}
+}
+
+// failingWriter fails after writing n bytes.
+type failingWriter struct {
+	n int
+}
+
+var errTestWrite = errors.New("test write error")
+
+func (fw *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > fw.n {
+		n := fw.n
+		fw.n = 0
+		return n, errTestWrite
+	}
+	fw.n -= len(p)
+	return len(p), nil
+}
+
+func TestRenderToWriteError(t *testing.T) {
+	ai := loadTestImage(t, 200, 40, Options{ColorDepth: ColorDepth256})
+	var out bytes.Buffer
+	if _, err := ai.RenderTo(&out, RenderOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	before := runtime.NumGoroutine()
+	for _, procs := range []int{1, 4, 16} {
+		ai.SetMaxProcs(procs)
+		fw := &failingWriter{n: out.Len() / 3} // fails in the middle, after flushing some rows
+		n, err := ai.RenderTo(fw, RenderOptions{})
+		if !errors.Is(err, errTestWrite) {
+			t.Errorf("maxprocs %d: RenderTo error = %v, want %v", procs, err, errTestWrite)
+		}
+		if n != int64(out.Len()/3) {
+			t.Errorf("maxprocs %d: RenderTo returned %d bytes, want %d", procs, n, out.Len()/3)
+		}
+	}
+
+	// workers are waited before RenderTo returns, but they may still be exiting
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("goroutines leaked: %d before, %d after", before, after)
+	}
+}
+
+func BenchmarkRenderTo(b *testing.B) {
+	for _, dm := range []DitheringMode{NoDithering, DitheringWithBlocks, DitheringWithBraille, DitheringWithGlyphs} {
+		ai := loadTestImage(b, 60, 200, Options{DitheringMode: dm})
+		for _, procs := range []int{1, 4} {
+			b.Run(fmt.Sprintf("mode%d/maxprocs%d", dm, procs), func(b *testing.B) {
+				ai.SetMaxProcs(procs)
+				b.ReportAllocs()
+				for b.Loop() {
+					if _, err := ai.RenderTo(io.Discard, RenderOptions{Optimize: true}); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestSetImageReusesPixmap(t *testing.T) {
+	for _, dm := range []DitheringMode{NoDithering, DitheringWithBlocks} {
+		ai := loadTestImage(t, 10, 20, Options{DitheringMode: dm})
+		pixmap := &ai.pixmap[0]
+		want := ai.Render()
+
+		if allocs := testing.AllocsPerRun(10, ai.Reset); allocs != 0 {
+			t.Errorf("mode %d: Reset allocates %v times, want 0", dm, allocs)
+		}
+		if p, _ := ai.GetAt(ai.Height()-1, ai.Width()-1); p.R != 0 || p.G != 0 || p.B != 0 || p.Brightness != 0 {
+			t.Errorf("mode %d: Reset left pixel %+v", dm, *p)
+		}
+
+		sy, sx := CellSize(dm)
+		if err := ai.SetImage(testImage(10*sy, 20*sx)); err != nil {
+			t.Fatal(err)
+		}
+		if &ai.pixmap[0] != pixmap || len(ai.pixmap) != ai.Height()*ai.Width() {
+			t.Errorf("mode %d: SetImage reallocated the pixmap", dm)
+		}
+		if got := ai.Render(); got != want {
+			t.Errorf("mode %d: SetImage after Reset renders a different image", dm)
+		}
+	}
+}
+
+func BenchmarkNewScaledFromImage(b *testing.B) {
+	sources := []struct {
+		name string
+		img  image.Image
+	}{
+		{"600x400", testImage(400, 600)}, // same size, so image is not resampled
+		{"4K", testImage(2160, 3840)},
+	}
+	for _, src := range sources {
+		for _, dm := range []DitheringMode{NoDithering, DitheringWithBlocks} {
+			b.Run(fmt.Sprintf("%s/mode%d", src.name, dm), func(b *testing.B) {
+				b.ReportAllocs()
+				for b.Loop() {
+					if _, err := NewScaledFromImage(src.img, 400, 600, color.Black, ScaleModeResize, dm); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// newForImage creates an empty ANSImage with the most terminal cells that fit in an image of size h*w.
+func newForImage(tb testing.TB, h, w int, bg color.Color, dm DitheringMode) *ANSImage {
+	tb.Helper()
+	sy, sx := CellSize(dm)
+	renderer, _ := LookupRenderer(dm)
+	cy, cx := renderer.CellSize()
+	ai, err := New(h/sy*cy, w/sx*cx, bg, dm)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return ai
+}
+
+// setImageAt refills ANSImage as SetImage did before reading RGBA.Pix in parallel,
+// with At() and colorful.MakeColor() per pixel, to compare them.
+func setImageAt(ai *ANSImage, img image.Image) {
+	py, px := ai.renderer.PixelSize()
+	rect := image.Rect(0, 0, ai.w*px, ai.h*py).Add(img.Bounds().Min)
+	rgbaOut := image.NewRGBA(rect)
+	if ai.bgOpaque {
+		draw.Draw(rgbaOut, rect, image.NewUniform(color.RGBA{R: ai.bgR, G: ai.bgG, B: ai.bgB, A: 255}), image.Point{}, draw.Src)
+		draw.Draw(rgbaOut, rect, img, rect.Min, draw.Over)
+	} else {
+		draw.Draw(rgbaOut, rect, img, rect.Min, draw.Src)
+	}
+
+	pixelCount := float64(py * px)
+	for y := 0; y < ai.h; y++ {
+		for x := 0; x < ai.w; x++ {
+			if pixelCount == 1 { // raw pixels are not averaged
+				c := rgbaOut.RGBAAt(rect.Min.X+x, rect.Min.Y+y)
+				ai.SetAt(y, x, c.R, c.G, c.B, max(c.R, c.G, c.B))
+				continue
+			}
+
+			var sumR, sumG, sumB, sumBri float64
+			for dy := 0; dy < py; dy++ {
+				for dx := 0; dx < px; dx++ {
+					c, _ := colorful.MakeColor(rgbaOut.At(rect.Min.X+px*x+dx, rect.Min.Y+py*y+dy))
+					_, _, v := c.Hsv()
+					sumR += c.R
+					sumG += c.G
+					sumB += c.B
+					sumBri += v
+				}
+			}
+			ai.SetAt(y, x,
+				uint8(sumR/pixelCount*255.0+0.5),
+				uint8(sumG/pixelCount*255.0+0.5),
+				uint8(sumB/pixelCount*255.0+0.5),
+				uint8(sumBri/pixelCount*255.0+0.5))
+		}
+	}
+}
+
+func TestSetImageMatchesAt(t *testing.T) {
+	// translucent image, not *image.RGBA, with bounds not starting at origin
+	src := testImage(64, 96)
+	img := image.NewNRGBA(image.Rect(5, 3, 101, 67))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 96; x++ {
+			c := src.RGBAAt(x, y)
+			img.SetNRGBA(x+5, y+3, color.NRGBA{R: c.R, G: c.G, B: c.B, A: uint8(x * 255 / 95)})
+		}
+	}
+
+	for _, dm := range registeredModes() {
+		for _, bg := range []color.Color{color.RGBA{R: 10, G: 80, B: 200, A: 255}, color.Transparent} {
+			want := newForImage(t, 64, 96, bg, dm)
+			got := newForImage(t, 64, 96, bg, dm)
+			setImageAt(want, img)
+
+			for _, procs := range []int{1, 3} {
+				got.SetMaxProcs(procs)
+				if err := got.SetImage(img); err != nil {
+					t.Fatal(err)
+				}
+				if !slices.Equal(got.pixmap, want.pixmap) {
+					t.Errorf("mode %d, bg %v, maxprocs %d: SetImage pixels differ from At() conversion", dm, bg, procs)
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkSetImage(b *testing.B) {
+	img := testImage(2160, 3840) // 4K
+	for _, dm := range []DitheringMode{NoDithering, DitheringWithBlocks} {
+		ai := newForImage(b, 2160, 3840, color.Black, dm)
+
+		b.Run(fmt.Sprintf("mode%d/At", dm), func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				setImageAt(ai, img)
+			}
+		})
+		for _, procs := range []int{1, 4} {
+			b.Run(fmt.Sprintf("mode%d/Pix/maxprocs%d", dm, procs), func(b *testing.B) {
+				ai.SetMaxProcs(procs)
+				b.ReportAllocs()
+				for b.Loop() {
+					if err := ai.SetImage(img); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,107 @@
+//       ___  _____  ____
+//      / _ \/  _/ |/_/ /____ ______ _
+//     / ___// /_>  </ __/ -_) __/  ' \
+//    /_/  /___/_/|_|\__/\__/_/ /_/_/_/
+//
+//    Copyright 2017 Eliuk Blau
+//
+//    This Source Code Form is subject to the terms of the Mozilla Public
+//    License, v. 2.0. If a copy of the MPL was not distributed with this
+//    file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package ansimage
+
+// Unicode Braille Patterns block, used to represent 2x4 pixels (dots) per terminal cell.
+// The pattern of each character is the offset from the first one (blank pattern).
+// INFO: https://en.wikipedia.org/wiki/Braille_Patterns
+const brailleBase = 0x2800
+
+// Minimum brightness contrast in a braille, mosaic or glyph cell to use its mean brightness as adaptive
+// threshold. Cells with lower contrast (flat areas) use the middle brightness instead.
+const cellMinContrast = 48
+
+// brailleDots are the bits of braille pattern dots, indexed by pixel coordinates (y,x) in the cell.
+var brailleDots = [BrailleSizeY][BrailleSizeX]rune{
+	{0x01, 0x08},
+	{0x02, 0x10},
+	{0x04, 0x20},
+	{0x40, 0x80},
+}
+
+// fixedThreshold returns the brightness threshold to light braille dots (or mosaic sub-cells),
+// or the middle brightness if ANSImage uses adaptive threshold.
+func (ai *ANSImage) fixedThreshold() uint8 {
+	if ai.threshold == 0 {
+		return 127
+	}
+	return ai.threshold
+}
+
+// cellThreshold returns the brightness threshold to light the dots of the braille cell
+// (or the sub-cells of the mosaic cell, or the pixels of the glyph cell) with size (sy,sx).
+func (ai *ANSImage) cellThreshold(cell Cell, sy, sx int) uint8 {
+	if ai.threshold != 0 {
+		return ai.threshold
+	}
+
+	sum, lo, hi := 0, uint8(255), uint8(0)
+	for dy := 0; dy < sy; dy++ {
+		for dx := 0; dx < sx; dx++ {
+			bri := cell.At(dy, dx).Brightness
+			sum += int(bri)
+			lo, hi = min(lo, bri), max(hi, bri)
+		}
+	}
+	if hi-lo < cellMinContrast {
+		return 127
+	}
+	return uint8(sum / (sy * sx))
+}
+
+// encodeBrailleCell returns the ANSI-compatible string form of the braille cell.
+// The lit dots are colored with their average color, over the background color.
+func (ai *ANSImage) encodeBrailleCell(rc *RenderContext, cell Cell) string {
+	threshold := ai.cellThreshold(cell, BrailleSizeY, BrailleSizeX)
+
+	var (
+		pattern          rune
+		lit              int
+		sumR, sumG, sumB int
+		sumBri           int
+	)
+	for dy := 0; dy < BrailleSizeY; dy++ {
+		for dx := 0; dx < BrailleSizeX; dx++ {
+			p := cell.At(dy, dx)
+			sumBri += int(p.Brightness)
+
+			isLit := p.Brightness > threshold
+			if ai.colordepth == ColorDepthNone && ai.inverted {
+				isLit = !isLit
+			}
+			if isLit {
+				pattern |= brailleDots[dy][dx]
+				sumR += int(p.R)
+				sumG += int(p.G)
+				sumB += int(p.B)
+				lit++
+			}
+		}
+	}
+
+	// WITHOUT COLOR
+	if ai.colordepth == ColorDepthNone {
+		if ai.asciiOnly {
+			// brightness ramp is inverted by glyphForBrightness
+			return ai.glyphForBrightness(uint8(sumBri / (BrailleSizeY * BrailleSizeX)))
+		}
+		if pattern == 0 {
+			return " "
+		}
+		return string(brailleBase + pattern)
+	}
+
+	if lit == 0 {
+		return rc.Paint(rc.BackgroundColor(), CellColor{}, " ")
+	}
+	return rc.Paint(rc.BackgroundColor(), CellRGB(uint8(sumR/lit), uint8(sumG/lit), uint8(sumB/lit)), string(brailleBase+pattern))
+}
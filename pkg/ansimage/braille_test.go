@@ -0,0 +1,106 @@
+//       ___  _____  ____
+//      / _ \/  _/ |/_/ /____ ______ _
+//     / ___// /_>  </ __/ -_) __/  ' \
+//    /_/  /___/_/|_|\__/\__/_/ /_/_/_/
+//
+//    Copyright 2017 Eliuk Blau
+//
+//    This Source Code Form is subject to the terms of the Mozilla Public
+//    License, v. 2.0. If a copy of the MPL was not distributed with this
+//    file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package ansimage
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"strconv"
+	"testing"
+)
+
+// cellImage returns an image with the pixels of rows: hex digits are gray levels
+// (0-f, times 0x11), and 'R', 'G' and 'B' are red, green and blue.
+func cellImage(rows ...string) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, len(rows[0]), len(rows)))
+	for y, row := range rows {
+		for x, p := range row {
+			c := color.RGBA{A: 0xff}
+			switch p {
+			case 'R':
+				c.R = 0xff
+			case 'G':
+				c.G = 0xff
+			case 'B':
+				c.B = 0xff
+			default:
+				v, _ := strconv.ParseUint(string(p), 16, 8)
+				c.R, c.G, c.B = uint8(v*0x11), uint8(v*0x11), uint8(v*0x11)
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// encodeFirstCell loads the image with options and returns the string form of its first
+// terminal cell, encoded by its Renderer with the render context.
+func encodeFirstCell(tb testing.TB, img image.Image, opts Options, rc RenderContext) string {
+	tb.Helper()
+	ai, err := loadImage(context.Background(), img, opts)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	rc.Image = ai
+	return ai.Renderer().EncodeCell(&rc, Cell{image: ai})
+}
+
+func TestBrailleDots(t *testing.T) {
+	tests := []struct {
+		name      string
+		rows      []string
+		threshold uint8
+		want      string
+	}{
+		{"blank", []string{"00", "00", "00", "00"}, 0x80, " "},
+		{"full", []string{"88", "88", "88", "88"}, 0x80, "⣿"},
+		{"below threshold", []string{"88", "88", "88", "88"}, 0x90, " "},
+		{"zigzag", []string{"f0", "0f", "f0", "0f"}, 0x80, "⢕"},          // dots 1, 5, 3, 8
+		{"left column", []string{"f0", "f0", "f0", "f0"}, 0x80, "⡇"},     // dots 1, 2, 3, 7
+		{"right column", []string{"0f", "0f", "0f", "0f"}, 0x80, "⢸"},    // dots 4, 5, 6, 8
+		{"bottom row", []string{"00", "00", "00", "ff"}, 0x80, "⣀"},      // dots 7, 8
+		{"adaptive", []string{"84", "84", "84", "84"}, 0, "⡇"},           // mean brightness of contrasted cell
+		{"adaptive flat", []string{"98", "89", "98", "89"}, 0, "⣿"},      // middle brightness of flat cell
+		{"adaptive flat dark", []string{"76", "67", "76", "67"}, 0, " "}, // middle brightness of flat cell
+		{"threshold is exclusive", []string{"80", "80", "80", "80"}, 0x88, " "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := Options{DitheringMode: DitheringWithBraille, ColorDepth: ColorDepthNone, Threshold: tt.threshold}
+			if got := encodeFirstCell(t, cellImage(tt.rows...), opts, RenderContext{}); got != tt.want {
+				t.Errorf("braille cell = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBrailleColors(t *testing.T) {
+	img := cellImage("f0", "d0", "f0", "d0")
+	opts := Options{DitheringMode: DitheringWithBraille, Threshold: 0x80, Matte: color.RGBA{0, 0, 0x40, 0xff}}
+
+	// lit dots take their mean color, over background color
+	want := "\033[48;2;0;0;64m\033[38;2;238;238;238m⡇"
+	if got := encodeFirstCell(t, img, opts, RenderContext{}); got != want {
+		t.Errorf("braille cell = %q, want %q", got, want)
+	}
+	want = "\033[38;2;238;238;238m⡇"
+	if got := encodeFirstCell(t, img, opts, RenderContext{DisableBgColor: true}); got != want {
+		t.Errorf("braille cell without background = %q, want %q", got, want)
+	}
+
+	// no lit dots is a space over background color
+	want = "\033[48;2;0;0;64m "
+	if got := encodeFirstCell(t, cellImage("00", "00", "00", "00"), opts, RenderContext{}); got != want {
+		t.Errorf("blank braille cell = %q, want %q", got, want)
+	}
+}
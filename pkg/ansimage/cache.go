@@ -0,0 +1,309 @@
+//       ___  _____  ____
+//      / _ \/  _/ |/_/ /____ ______ _
+//     / ___// /_>  </ __/ -_) __/  ' \
+//    /_/  /___/_/|_|\__/\__/_/ /_/_/_/
+//
+//    Copyright 2017 Eliuk Blau
+//
+//    This Source Code Form is subject to the terms of the Mozilla Public
+//    License, v. 2.0. If a copy of the MPL was not distributed with this
+//    file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package ansimage
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache defaults (used when its fields are zero)
+const (
+	DefaultCacheMaxBytes = 256 << 20 // 256 MiB
+	DefaultCacheName     = "ansimage"
+)
+
+// ErrNotCached occurs when a Cache is offline and it has no image data of an URL.
+var ErrNotCached = errors.New("ANSImage: image is not cached (offline mode)")
+
+// file extensions of cache entries: image data and its metadata
+const (
+	cacheDataExt = ".data"
+	cacheMetaExt = ".json"
+)
+
+// Cache is an on-disk HTTP cache of downloaded images, used by Fetcher. Cached images
+// are fresh as long as Cache-Control (max-age, no-cache, no-store) or Expires headers
+// allow it, otherwise they are revalidated with ETag and Last-Modified headers.
+// Images are cached by URL and extra headers of Fetcher (e.g. Authorization or Cookie),
+// so requests with different headers never share them; responses with "Vary: *" are not cached.
+// When cache is bigger than its max size, least recently used images are evicted.
+// Zero value is ready to use with default settings.
+type Cache struct {
+	Dir      string // cache directory (optional, default: DefaultCacheName in user cache directory, e.g. $XDG_CACHE_HOME)
+	MaxBytes int64  // max size of cached image data (optional, default: DefaultCacheMaxBytes; <0 is unlimited)
+	Offline  bool   // only use cached images, even if stale, without downloading them (optional)
+
+	mu sync.Mutex // guards evictions
+}
+
+// cacheMeta is the metadata of a cached image.
+type cacheMeta struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Expires      time.Time `json:"expires"`
+}
+
+// dir returns the cache directory.
+func (c *Cache) dir() (string, error) {
+	if c.Dir != "" {
+		return c.Dir, nil
+	}
+	dir, err := os.UserCacheDir() // honors XDG_CACHE_HOME
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, DefaultCacheName), nil
+}
+
+// path returns the path of a cache entry of an URL requested with extra headers, without file extension.
+func (c *Cache) path(url string, header http.Header) (string, error) {
+	dir, err := c.dir()
+	if err != nil {
+		return "", err
+	}
+
+	canonical := http.Header{} // same headers with other case of names have the same key
+	for name, values := range header {
+		for _, value := range values {
+			canonical.Add(name, value)
+		}
+	}
+	var key strings.Builder
+	key.WriteString(url + "\n")
+	canonical.Write(&key) // sorted by name
+
+	sum := sha256.Sum256([]byte(key.String()))
+	return filepath.Join(dir, hex.EncodeToString(sum[:])), nil
+}
+
+// Purge removes all cached images.
+func (c *Cache) Purge() error {
+	dir, err := c.dir()
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if ext := filepath.Ext(entry.Name()); ext == cacheDataExt || ext == cacheMetaExt || ext == ".tmp" {
+			if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fetch returns the image data of an URL from cache, downloading it with Fetcher
+// if it is not cached or it is stale and modified. Cache errors are not failures,
+// because image can always be downloaded again.
+func (c *Cache) fetch(ctx context.Context, f *Fetcher, url string) ([]byte, error) {
+	path, err := c.path(url, f.Header)
+	if err != nil {
+		if c.Offline {
+			return nil, &FetchError{URL: url, Err: err}
+		}
+		res, err := f.get(ctx, url, nil)
+		return res.data, err
+	}
+
+	meta, data, cached := c.load(path, url)
+	if cached && (c.Offline || time.Now().Before(meta.Expires)) {
+		c.touch(path)
+		return data, nil
+	}
+	if c.Offline {
+		return nil, &FetchError{URL: url, Err: ErrNotCached}
+	}
+
+	header := http.Header{}
+	if cached {
+		if meta.ETag != "" {
+			header.Set("If-None-Match", meta.ETag)
+		}
+		if meta.LastModified != "" {
+			header.Set("If-Modified-Since", meta.LastModified)
+		}
+	}
+
+	res, err := f.get(ctx, url, header)
+	if err != nil {
+		return nil, err
+	}
+	if res.notModified {
+		meta.Expires = expiresAt(res.header)
+		meta.ETag = cmp.Or(res.header.Get("ETag"), meta.ETag)
+		meta.LastModified = cmp.Or(res.header.Get("Last-Modified"), meta.LastModified)
+		c.store(path, meta, nil)
+		return data, nil
+	}
+
+	noStore := strings.Contains(strings.ToLower(res.header.Get("Cache-Control")), "no-store")
+	if !noStore && !strings.Contains(res.header.Get("Vary"), "*") { // "*" varies by more than headers of Fetcher
+		c.store(path, cacheMeta{
+			URL:          url,
+			ETag:         res.header.Get("ETag"),
+			LastModified: res.header.Get("Last-Modified"),
+			Expires:      expiresAt(res.header),
+		}, res.data)
+	}
+	return res.data, nil
+}
+
+// load reads a cache entry, and reports if it exists.
+func (c *Cache) load(path, url string) (cacheMeta, []byte, bool) {
+	var meta cacheMeta
+	raw, err := os.ReadFile(path + cacheMetaExt)
+	if err != nil || json.Unmarshal(raw, &meta) != nil || meta.URL != url {
+		return meta, nil, false
+	}
+	data, err := os.ReadFile(path + cacheDataExt)
+	if err != nil {
+		return meta, nil, false
+	}
+	return meta, data, true
+}
+
+// store writes a cache entry (only its metadata if data is nil) and evicts images over max size.
+func (c *Cache) store(path string, meta cacheMeta, data []byte) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return
+	}
+	if data != nil && writeFileAtomic(path+cacheDataExt, data) != nil {
+		return
+	}
+	raw, err := json.Marshal(meta)
+	if err != nil || writeFileAtomic(path+cacheMetaExt, raw) != nil {
+		return
+	}
+	c.touch(path)
+	if data != nil {
+		c.evict(filepath.Dir(path))
+	}
+}
+
+// touch sets the access time of a cache entry for LRU eviction.
+func (c *Cache) touch(path string) {
+	now := time.Now()
+	os.Chtimes(path+cacheDataExt, now, now)
+}
+
+// evict removes least recently used images until cache is not bigger than max size.
+func (c *Cache) evict(dir string) {
+	maxBytes := cmp.Or(c.MaxBytes, DefaultCacheMaxBytes)
+	if maxBytes < 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	var (
+		files []fs.FileInfo
+		size  int64
+	)
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) != cacheDataExt {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			files = append(files, info)
+			size += info.Size()
+		}
+	}
+
+	slices.SortFunc(files, func(a, b fs.FileInfo) int {
+		return a.ModTime().Compare(b.ModTime())
+	})
+	for _, info := range files {
+		if size <= maxBytes {
+			break
+		}
+		path := filepath.Join(dir, strings.TrimSuffix(info.Name(), cacheDataExt))
+		os.Remove(path + cacheMetaExt)
+		if os.Remove(path+cacheDataExt) == nil {
+			size -= info.Size()
+		}
+	}
+}
+
+// expiresAt returns the expiration time of a response by its Cache-Control or Expires headers.
+// Without them, or with no-cache directive, response is already stale (it must be revalidated).
+func expiresAt(header http.Header) time.Time {
+	now := time.Now()
+	if cacheControl := header.Get("Cache-Control"); cacheControl != "" {
+		for directive := range strings.SplitSeq(strings.ToLower(cacheControl), ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			switch name {
+			case "no-cache", "no-store":
+				return now
+			case "max-age":
+				seconds, err := strconv.ParseInt(strings.Trim(value, `"`), 10, 64)
+				if err != nil {
+					return now
+				}
+				age, _ := strconv.ParseInt(header.Get("Age"), 10, 64)
+				return now.Add(time.Duration(max(seconds-age, 0)) * time.Second)
+			}
+		}
+	}
+	if expires, err := http.ParseTime(header.Get("Expires")); err == nil {
+		if date, err := http.ParseTime(header.Get("Date")); err == nil {
+			return now.Add(expires.Sub(date)) // avoids clock skew with server
+		}
+		return expires
+	}
+	return now
+}
+
+// writeFileAtomic writes a file through a temporary file, so readers never see it partially written.
+func writeFileAtomic(name string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), name)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
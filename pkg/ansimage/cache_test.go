@@ -0,0 +1,248 @@
+//       ___  _____  ____
+//      / _ \/  _/ |/_/ /____ ______ _
+//     / ___// /_>  </ __/ -_) __/  ' \
+//    /_/  /___/_/|_|\__/\__/_/ /_/_/_/
+//
+//    Copyright 2017 Eliuk Blau
+//
+//    This Source Code Form is subject to the terms of the Mozilla Public
+//    License, v. 2.0. If a copy of the MPL was not distributed with this
+//    file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package ansimage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fetchCached fetches an URL with the Fetcher, failing unless it returns the data.
+func fetchCached(tb testing.TB, f *Fetcher, url string, data []byte) {
+	tb.Helper()
+	got, err := f.Fetch(context.Background(), url)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		tb.Fatalf("Fetch returned %d bytes, want %d", len(got), len(data))
+	}
+}
+
+func TestCacheRevalidate(t *testing.T) {
+	data := testPNG(t)
+	tests := []struct {
+		name             string
+		validator, value string // header of response
+		conditional      string // header of revalidation request
+		cacheControl     string
+	}{
+		{"etag", "ETag", `"v1"`, "If-None-Match", "no-cache"},
+		{"last modified", "Last-Modified", "Mon, 02 Jan 2017 15:04:05 GMT", "If-Modified-Since", ""},
+		{"expired", "ETag", `"v1"`, "If-None-Match", "max-age=0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, requests := testServer(t, func(w http.ResponseWriter, r *http.Request, n int) {
+				w.Header().Set(tt.validator, tt.value)
+				if tt.cacheControl != "" {
+					w.Header().Set("Cache-Control", tt.cacheControl)
+				}
+				if r.Header.Get(tt.conditional) == tt.value {
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				if n > 1 {
+					t.Errorf("request %d has %s %q, want %q", n, tt.conditional, r.Header.Get(tt.conditional), tt.value)
+				}
+				w.Write(data)
+			})
+
+			f := &Fetcher{Cache: &Cache{Dir: t.TempDir()}}
+			for i := 0; i < 3; i++ {
+				fetchCached(t, f, srv.URL, data) // stale, so it is revalidated every time
+			}
+			if n := requests.Load(); n != 3 {
+				t.Errorf("%d requests, want 3", n)
+			}
+		})
+	}
+}
+
+func TestCacheFresh(t *testing.T) {
+	data := testPNG(t)
+	tests := []struct {
+		name   string
+		header http.Header
+		want   int32 // requests of 3 fetches
+	}{
+		{"max-age", http.Header{"Cache-Control": {"public, max-age=60"}}, 1},
+		{"max-age over age", http.Header{"Cache-Control": {"max-age=60"}, "Age": {"60"}}, 3},
+		{"expires", http.Header{"Date": {"Mon, 02 Jan 2017 15:04:05 GMT"}, "Expires": {"Mon, 02 Jan 2017 16:04:05 GMT"}}, 1},
+		{"no-store", http.Header{"Cache-Control": {"no-store"}, "ETag": {`"v1"`}}, 3},
+		{"vary all", http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"*"}}, 3},
+		{"no headers", http.Header{}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, requests := testServer(t, func(w http.ResponseWriter, r *http.Request, n int) {
+				for name, values := range tt.header {
+					w.Header()[name] = values
+				}
+				w.Write(data)
+			})
+
+			f := &Fetcher{Cache: &Cache{Dir: t.TempDir()}}
+			for i := 0; i < 3; i++ {
+				fetchCached(t, f, srv.URL, data)
+			}
+			if n := requests.Load(); n != tt.want {
+				t.Errorf("%d requests, want %d", n, tt.want)
+			}
+		})
+	}
+}
+
+func TestCacheNoStoreFiles(t *testing.T) {
+	data := testPNG(t)
+	srv, _ := testServer(t, func(w http.ResponseWriter, r *http.Request, n int) {
+		w.Header().Set("Cache-Control", "private, no-store")
+		w.Write(data)
+	})
+
+	dir := t.TempDir()
+	fetchCached(t, &Fetcher{Cache: &Cache{Dir: dir}}, srv.URL, data)
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("cache has %d files, want none", len(entries))
+	}
+}
+
+func TestCacheOffline(t *testing.T) {
+	data := testPNG(t)
+	srv, requests := testServer(t, func(w http.ResponseWriter, r *http.Request, n int) {
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write(data)
+	})
+
+	cache := &Cache{Dir: t.TempDir(), Offline: true}
+	f := &Fetcher{Cache: cache}
+	_, err := f.Fetch(context.Background(), srv.URL)
+	var fe *FetchError
+	if !errors.As(err, &fe) || !errors.Is(err, ErrNotCached) || fe.URL != srv.URL {
+		t.Fatalf("Fetch error = %v, want FetchError with %v", err, ErrNotCached)
+	}
+
+	cache.Offline = false
+	fetchCached(t, f, srv.URL, data)
+	cache.Offline = true
+	fetchCached(t, f, srv.URL, data) // stale, but offline
+	if n := requests.Load(); n != 1 {
+		t.Errorf("%d requests, want 1", n)
+	}
+}
+
+func TestCacheHeaders(t *testing.T) {
+	srv, requests := testServer(t, func(w http.ResponseWriter, r *http.Request, n int) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Authorization")
+		w.Write([]byte("GIF89a " + r.Header.Get("Authorization")))
+	})
+
+	cache := &Cache{Dir: t.TempDir()}
+	alice := &Fetcher{Cache: cache, Header: http.Header{"Authorization": {"Bearer alice"}}}
+	bob := &Fetcher{Cache: cache, Header: http.Header{"Authorization": {"Bearer bob"}}}
+	anonymous := &Fetcher{Cache: cache}
+
+	fetchCached(t, alice, srv.URL, []byte("GIF89a Bearer alice"))
+	fetchCached(t, bob, srv.URL, []byte("GIF89a Bearer bob"))
+	fetchCached(t, anonymous, srv.URL, []byte("GIF89a "))
+	if n := requests.Load(); n != 3 {
+		t.Errorf("%d requests, want 3 (one per credentials)", n)
+	}
+
+	// same headers, with other case of names
+	alice = &Fetcher{Cache: cache, Header: http.Header{"authorization": {"Bearer alice"}}}
+	fetchCached(t, alice, srv.URL, []byte("GIF89a Bearer alice"))
+	if n := requests.Load(); n != 3 {
+		t.Errorf("%d requests, want 3 (cached)", n)
+	}
+}
+
+func TestCacheEvict(t *testing.T) {
+	data := testPNG(t)
+	srv, _ := testServer(t, func(w http.ResponseWriter, r *http.Request, n int) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write(data)
+	})
+
+	cache := &Cache{Dir: t.TempDir(), MaxBytes: int64(2 * len(data))}
+	f := &Fetcher{Cache: cache}
+	urls := []string{srv.URL + "/a", srv.URL + "/b", srv.URL + "/c"}
+
+	// a is fetched before b, but it is used after b
+	age := func(url string, d time.Duration) {
+		path, err := cache.path(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		then := time.Now().Add(-d)
+		if err := os.Chtimes(path+cacheDataExt, then, then); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fetchCached(t, f, urls[0], data)
+	age(urls[0], 2*time.Hour)
+	fetchCached(t, f, urls[1], data)
+	age(urls[1], time.Hour)
+	fetchCached(t, f, urls[0], data) // touched
+	fetchCached(t, f, urls[2], data) // evicts b
+
+	cache.Offline = true
+	for i, url := range urls {
+		_, err := f.Fetch(context.Background(), url)
+		if wantEvicted := i == 1; errors.Is(err, ErrNotCached) != wantEvicted {
+			t.Errorf("%s: Fetch error = %v, evicted %v", url, err, wantEvicted)
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(cache.Dir, "*"))
+	if len(files) != 4 {
+		t.Errorf("cache has %d files, want 4 (data and metadata of 2 images)", len(files))
+	}
+}
+
+func TestCachePurge(t *testing.T) {
+	data := testPNG(t)
+	srv, requests := testServer(t, func(w http.ResponseWriter, r *http.Request, n int) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write(data)
+	})
+
+	cache := &Cache{Dir: t.TempDir()}
+	other := filepath.Join(cache.Dir, "other.txt")
+	if err := os.WriteFile(other, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f := &Fetcher{Cache: cache}
+	fetchCached(t, f, srv.URL, data)
+
+	if err := cache.Purge(); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(cache.Dir, "*"))
+	if len(files) != 1 || files[0] != other {
+		t.Errorf("cache has files %v after purge, want only %s", files, other)
+	}
+	fetchCached(t, f, srv.URL, data)
+	if n := requests.Load(); n != 2 {
+		t.Errorf("%d requests, want 2 (downloaded again)", n)
+	}
+
+	if err := (&Cache{Dir: filepath.Join(cache.Dir, "missing")}).Purge(); err != nil {
+		t.Errorf("Purge of missing cache: %v", err)
+	}
+}
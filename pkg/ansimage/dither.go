@@ -0,0 +1,347 @@
+//       ___  _____  ____
+//      / _ \/  _/ |/_/ /____ ______ _
+//     / ___// /_>  </ __/ -_) __/  ' \
+//    /_/  /___/_/|_|\__/\__/_/ /_/_/_/
+//
+//    Copyright 2017 Eliuk Blau
+//
+//    This Source Code Form is subject to the terms of the Mozilla Public
+//    License, v. 2.0. If a copy of the MPL was not distributed with this
+//    file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package ansimage
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// ANSImage color dithering algorithms, used when colors are reduced (or without color):
+// none (nearest color, default),
+// Floyd-Steinberg, Atkinson, Jarvis-Judice-Ninke, Sierra and Stucki error diffusion,
+// Bayer 2x2, 4x4 and 8x8 and blue noise ordered dithering (stable for animations,
+// because each pixel does not depend on the others).
+// (Nice info for error diffusion - https://tannerhelland.com/2012/12/28/dithering-eleven-algorithms-source-code.html)
+// (Nice info for ordered dithering - https://en.wikipedia.org/wiki/Ordered_dithering)
+const (
+	NoDither = Dither(iota)
+	DitherFloydSteinberg
+	DitherAtkinson
+	DitherJarvisJudiceNinke
+	DitherSierra
+	DitherStucki
+	DitherBayer2x2
+	DitherBayer4x4
+	DitherBayer8x8
+	DitherBlueNoise
+)
+
+// Dither type is used for color dithering algorithm constants.
+type Dither uint8
+
+// diffusionKernel distributes the quantization error of a pixel to its neighbours,
+// in proportion to the weights (over divisor). Neighbours are relative to the pixel
+// in scan direction, so they are mirrored in right-to-left rows.
+type diffusionKernel struct {
+	divisor float64
+	weights []diffusionWeight
+}
+
+// diffusionWeight is the weight of the neighbour pixel in (dy,dx) offset.
+type diffusionWeight struct {
+	dy, dx int
+	weight float64
+}
+
+// diffusionKernels are the error diffusion kernels, indexed by dithering algorithm.
+var diffusionKernels = map[Dither]diffusionKernel{
+	DitherFloydSteinberg: {16, []diffusionWeight{
+		{0, 1, 7},
+		{1, -1, 3}, {1, 0, 5}, {1, 1, 1},
+	}},
+	DitherAtkinson: {8, []diffusionWeight{ // only 3/4 of error is diffused
+		{0, 1, 1}, {0, 2, 1},
+		{1, -1, 1}, {1, 0, 1}, {1, 1, 1},
+		{2, 0, 1},
+	}},
+	DitherJarvisJudiceNinke: {48, []diffusionWeight{
+		{0, 1, 7}, {0, 2, 5},
+		{1, -2, 3}, {1, -1, 5}, {1, 0, 7}, {1, 1, 5}, {1, 2, 3},
+		{2, -2, 1}, {2, -1, 3}, {2, 0, 5}, {2, 1, 3}, {2, 2, 1},
+	}},
+	DitherSierra: {32, []diffusionWeight{
+		{0, 1, 5}, {0, 2, 3},
+		{1, -2, 2}, {1, -1, 4}, {1, 0, 5}, {1, 1, 4}, {1, 2, 2},
+		{2, -1, 2}, {2, 0, 3}, {2, 1, 2},
+	}},
+	DitherStucki: {42, []diffusionWeight{
+		{0, 1, 8}, {0, 2, 4},
+		{1, -2, 2}, {1, -1, 4}, {1, 0, 8}, {1, 1, 4}, {1, 2, 2},
+		{2, -2, 1}, {2, -1, 2}, {2, 0, 4}, {2, 1, 2}, {2, 2, 1},
+	}},
+}
+
+// thresholdMatrix is a square matrix of thresholds (in -0.5 to 0.5 range) for ordered dithering,
+// tiled over the image. The threshold of each pixel is added to its color, scaled to the
+// difference between the colors that ANSImage renders, before finding the nearest one.
+type thresholdMatrix struct {
+	size   int
+	values []float64
+}
+
+// newThresholdMatrix creates a thresholdMatrix from the ranks (0 to size*size-1) of its cells.
+func newThresholdMatrix(size int, ranks []int) thresholdMatrix {
+	tm := thresholdMatrix{size: size, values: make([]float64, len(ranks))}
+	for i, r := range ranks {
+		tm.values[i] = (float64(r)+0.5)/float64(len(ranks)) - 0.5
+	}
+	return tm
+}
+
+// at returns the threshold of the pixel (y,x).
+func (tm thresholdMatrix) at(y, x int) float64 {
+	return tm.values[(y%tm.size)*tm.size+x%tm.size]
+}
+
+// bayerMatrix returns the Bayer threshold matrix with the size (power of two).
+func bayerMatrix(size int) thresholdMatrix {
+	ranks := []int{0}
+	for n := 1; n < size; n *= 2 {
+		next := make([]int, 4*n*n)
+		for y := 0; y < n; y++ {
+			for x := 0; x < n; x++ {
+				r := 4 * ranks[y*n+x]
+				next[y*2*n+x] = r
+				next[y*2*n+x+n] = r + 2
+				next[(y+n)*2*n+x] = r + 3
+				next[(y+n)*2*n+x+n] = r + 1
+			}
+		}
+		ranks = next
+	}
+	return newThresholdMatrix(size, ranks)
+}
+
+// blueNoiseRanks are the ranks of a 16x16 blue noise threshold texture, generated with
+// the void-and-cluster method (gaussian filter with sigma 1.5, toroidal distances).
+// (info - https://momentsingraphics.de/BlueNoise.html)
+var blueNoiseRanks = []int{
+	137, 235, 63, 20, 47, 209, 89, 164, 25, 103, 154, 248, 92, 46, 189, 18,
+	170, 95, 186, 151, 250, 173, 127, 68, 244, 197, 8, 66, 168, 230, 119, 252,
+	50, 9, 222, 77, 114, 3, 224, 35, 146, 52, 129, 211, 22, 143, 34, 85,
+	213, 122, 141, 37, 203, 60, 90, 183, 110, 236, 83, 184, 106, 69, 205, 157,
+	178, 65, 247, 100, 159, 241, 131, 215, 10, 162, 38, 228, 153, 243, 1, 101,
+	233, 28, 169, 11, 190, 49, 24, 152, 64, 204, 97, 17, 53, 118, 194, 44,
+	86, 112, 207, 78, 136, 102, 199, 82, 255, 123, 175, 139, 218, 75, 165, 135,
+	216, 148, 55, 226, 30, 234, 172, 113, 27, 42, 231, 88, 180, 31, 253, 21,
+	188, 5, 177, 120, 155, 58, 0, 219, 147, 192, 61, 6, 111, 201, 96, 62,
+	239, 104, 41, 246, 91, 202, 132, 71, 98, 245, 163, 130, 238, 51, 149, 126,
+	79, 161, 206, 73, 16, 166, 240, 36, 185, 15, 80, 212, 23, 171, 227, 13,
+	214, 134, 26, 144, 191, 48, 109, 210, 150, 121, 45, 142, 105, 70, 193, 43,
+	181, 59, 254, 99, 225, 128, 84, 19, 67, 229, 200, 167, 251, 29, 124, 94,
+	2, 116, 174, 40, 7, 182, 158, 249, 176, 93, 4, 57, 87, 208, 145, 242,
+	223, 81, 156, 217, 76, 237, 56, 115, 39, 138, 232, 117, 179, 12, 160, 54,
+	196, 32, 125, 198, 107, 140, 14, 195, 220, 72, 187, 33, 133, 221, 74, 108,
+}
+
+// thresholdMatrices are the ordered dithering matrices, indexed by dithering algorithm.
+var thresholdMatrices = map[Dither]thresholdMatrix{
+	DitherBayer2x2:  bayerMatrix(2),
+	DitherBayer4x4:  bayerMatrix(4),
+	DitherBayer8x8:  bayerMatrix(8),
+	DitherBlueNoise: newThresholdMatrix(16, blueNoiseRanks),
+}
+
+// Dither gets the color dithering algorithm of ANSImage.
+func (ai *ANSImage) Dither() Dither {
+	return ai.dither
+}
+
+// SetDither sets the color dithering algorithm of ANSImage. It is applied by SetImage to the pixels
+// of the image, before they are sampled to ANSI-pixels, with reduced color depth (nearest colors
+// of the palette) or without color (brightness levels of the glyphs); so it must be set before,
+// along with the color depth, palette, ASCII only and threshold settings (see Options).
+func (ai *ANSImage) SetDither(d Dither) {
+	ai.dither = d
+}
+
+// DitherStrength gets the amount of quantization error diffused (or threshold matrix
+// added) by ANSImage (0-1).
+func (ai *ANSImage) DitherStrength() float64 {
+	return ai.ditherStrength
+}
+
+// SetDitherStrength sets the amount of quantization error diffused (or threshold matrix
+// added) by ANSImage, from 0 (none, same as nearest color) to 1 (all, default).
+func (ai *ANSImage) SetDitherStrength(strength float64) {
+	ai.ditherStrength = min(max(strength, 0), 1)
+}
+
+// Serpentine gets if ANSImage diffuses the quantization error with serpentine scanning.
+func (ai *ANSImage) Serpentine() bool {
+	return ai.serpentine
+}
+
+// SetSerpentine sets if ANSImage diffuses the quantization error with serpentine scanning
+// (alternating left-to-right and right-to-left rows, enabled by default), which avoids
+// the diagonal artifacts of scanning all rows in the same direction.
+func (ai *ANSImage) SetSerpentine(serpentine bool) {
+	ai.serpentine = serpentine
+}
+
+// isValid reports if the color dithering algorithm is known.
+func (d Dither) isValid() bool {
+	_, diffusion := diffusionKernels[d]
+	_, ordered := thresholdMatrices[d]
+	return d == NoDither || diffusion || ordered
+}
+
+// dithers reports if ANSImage quantizes the pixels of images with its dithering algorithm,
+// that is with a reduced color depth or without color.
+func (ai *ANSImage) dithers() bool {
+	return ai.dither != NoDither && ai.colordepth != ColorDepthTrueColor
+}
+
+// ditherImage quantizes the pixels of an image (before they are sampled to ANSI-pixels) to the
+// colors (or brightness levels without color, as gray) that ANSImage renders, diffusing the
+// quantization error to neighbour pixels or adding the threshold matrix to them.
+// Pixels with transparency are not quantized.
+func (ai *ANSImage) ditherImage(rgba *image.RGBA) {
+	kernel, diffusion := diffusionKernels[ai.dither]
+	matrix, ordered := thresholdMatrices[ai.dither]
+	if !diffusion && !ordered {
+		panic(ErrUnknownDither) // checked by SetImage
+	}
+
+	spread := ai.colorStep()
+	if ai.colordepth == ColorDepthNone {
+		spread = ai.brightnessStep()
+	}
+
+	bounds := rgba.Bounds()
+	h, w := bounds.Dy(), bounds.Dx()
+	var errs [3][][3]float64 // diffused error of current and next 2 rows (ring buffer)
+	if diffusion {
+		for i := range errs {
+			errs[i] = make([][3]float64, w)
+		}
+	}
+
+	for y := 0; y < h; y++ {
+		x0, dir := 0, 1
+		if ai.serpentine && y%2 == 1 {
+			x0, dir = w-1, -1 // right to left
+		}
+
+		for i := 0; i < w; i++ {
+			x := x0 + i*dir
+			c := rgba.Pix[rgba.PixOffset(bounds.Min.X+x, bounds.Min.Y+y):]
+			if c[3] != 0xff {
+				continue // transparent pixels are composited by terminal
+			}
+
+			v := [3]float64{float64(c[0]), float64(c[1]), float64(c[2])}
+			if ai.colordepth == ColorDepthNone {
+				v[0] = float64(max(c[0], c[1], c[2])) // same as HSV value
+			}
+			for k := range v {
+				if diffusion {
+					v[k] += errs[y%3][x][k]
+				} else {
+					v[k] += matrix.at(y, x) * spread * ai.ditherStrength
+				}
+				v[k] = min(max(v[k], 0), 255)
+			}
+
+			var e [3]float64
+			if ai.colordepth == ColorDepthNone {
+				level := ai.brightnessLevel(v[0])
+				c[0] = uint8(level + 0.5)
+				c[1], c[2] = c[0], c[0]
+				e[0] = v[0] - level
+			} else {
+				q := ai.nearestColor(uint8(v[0]+0.5), uint8(v[1]+0.5), uint8(v[2]+0.5))
+				c[0], c[1], c[2] = q.R, q.G, q.B
+				e = [3]float64{v[0] - float64(q.R), v[1] - float64(q.G), v[2] - float64(q.B)}
+			}
+			if !diffusion {
+				continue
+			}
+
+			for _, k := range kernel.weights {
+				ny, nx := y+k.dy, x+k.dx*dir
+				if ny >= h || nx < 0 || nx >= w {
+					continue
+				}
+				f := ai.ditherStrength * k.weight / kernel.divisor
+				n := &errs[ny%3][nx]
+				for j := range e {
+					n[j] += e[j] * f
+				}
+			}
+		}
+
+		if diffusion {
+			clear(errs[y%3]) // reused for row y+3
+		}
+	}
+}
+
+// nearestColor returns the nearest color to (r,g,b) that ANSImage renders in its color depth.
+func (ai *ANSImage) nearestColor(r, g, b uint8) color.RGBA {
+	switch ai.colordepth {
+	case ColorDepthTrueColor:
+		return color.RGBA{r, g, b, 0xff}
+	case ColorDepth256:
+		return xterm256.nearest(r, g, b)
+	case ColorDepth16:
+		return ai.palette16.nearest(r, g, b)
+	case ColorDepth8:
+		return ai.palette8.nearest(r, g, b)
+	default:
+		panic(ErrUnknownColorDepth) // checked by SetColorDepth
+	}
+}
+
+// colorStep returns the approximate difference between the levels of each color channel
+// in the colors that ANSImage renders in its color depth.
+func (ai *ANSImage) colorStep() float64 {
+	switch ai.colordepth {
+	case ColorDepth256:
+		return 255.0 / 5 // 6 levels of color cube
+	case ColorDepth16:
+		return 255.0 / 2 // basic and bright colors
+	default:
+		return 255
+	}
+}
+
+// brightnessStep returns the difference between the brightness levels that ANSImage renders
+// without color: a step of the chars or blocks brightness ramps (see glyphForBrightness),
+// or lit/unlit by threshold for half blocks, braille dots, mosaic sub-cells and glyph pixels.
+func (ai *ANSImage) brightnessStep() float64 {
+	switch {
+	case ai.asciiOnly || ai.dithering == DitheringWithChars:
+		return 255.0 / 10 // 10 chars, plus space
+	case ai.dithering == DitheringWithBlocks:
+		return 255.0 / 4 // 4 blocks, plus space
+	}
+	return 255
+}
+
+// brightnessLevel returns the brightness level that ANSImage renders without color for the brightness.
+func (ai *ANSImage) brightnessLevel(bri float64) float64 {
+	if step := ai.brightnessStep(); step < 255 {
+		return math.Round(bri/step) * step
+	}
+
+	threshold := float64(ai.fixedThreshold())
+	if ai.dithering == NoDithering {
+		threshold = 127 // see halfBlocksForBrightness
+	}
+	if bri > threshold {
+		return 255
+	}
+	return 0
+}
@@ -0,0 +1,263 @@
+//       ___  _____  ____
+//      / _ \/  _/ |/_/ /____ ______ _
+//     / ___// /_>  </ __/ -_) __/  ' \
+//    /_/  /___/_/|_|\__/\__/_/ /_/_/_/
+//
+//    Copyright 2017 Eliuk Blau
+//
+//    This Source Code Form is subject to the terms of the Mozilla Public
+//    License, v. 2.0. If a copy of the MPL was not distributed with this
+//    file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package ansimage
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"testing"
+)
+
+// grayImage returns an image of size (h,w) filled with the gray level.
+func grayImage(h, w int, level uint8) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Gray{level}}, image.Point{}, draw.Src)
+	return img
+}
+
+// newDitherer returns an ANSImage that dithers without color to lit and unlit pixels
+// (braille dots with threshold 127), to check the quantization of dithering algorithms.
+func newDitherer(tb testing.TB, d Dither) *ANSImage {
+	tb.Helper()
+	ai, err := New(BrailleSizeY, BrailleSizeX, color.Black, DitheringWithBraille)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err := ai.SetColorDepth(ColorDepthNone); err != nil {
+		tb.Fatal(err)
+	}
+	ai.SetDither(d)
+	return ai
+}
+
+// litFraction returns the fraction of lit (white) pixels of the image.
+func litFraction(img *image.RGBA) float64 {
+	lit := 0
+	for i := 0; i < len(img.Pix); i += 4 {
+		if img.Pix[i] == 0xff {
+			lit++
+		}
+	}
+	return float64(lit) / float64(len(img.Pix)/4)
+}
+
+func TestDiffusionFlatGray(t *testing.T) {
+	for _, level := range []uint8{32, 64, 128, 192, 224} {
+		for d := DitherFloydSteinberg; d <= DitherStucki; d++ {
+			// error diffusion keeps the mean brightness, except Atkinson that diffuses only 3/4 of error
+			// (it loses shadows and highlights, so only mid tones are close to the mean)
+			want, tolerance := float64(level)/255, 0.02
+			if d == DitherAtkinson {
+				if level < 64 || level > 192 {
+					continue
+				}
+				tolerance = 0.1
+			}
+
+			for _, serpentine := range []bool{true, false} {
+				ai := newDitherer(t, d)
+				ai.SetSerpentine(serpentine)
+				img := grayImage(64, 64, level)
+				ai.ditherImage(img)
+				if got := litFraction(img); math.Abs(got-want) > tolerance {
+					t.Errorf("dither %d, level %d, serpentine %v: %.3f of pixels lit, want %.3f", d, level, serpentine, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestDiffusionSerpentine(t *testing.T) {
+	// Floyd-Steinberg of a 2x2 gray image (level 64) with threshold 127: only the error of row 0
+	// reaches row 1 from left to right (37.25 and 4), so the pixel of row 1 that is scanned last
+	// gets also 7/16 of the error of the first one, and it is the only lit pixel.
+	tests := []struct {
+		serpentine bool
+		want       []uint8 // brightness of pixels, row by row
+	}{
+		{false, []uint8{0, 0, 0, 255}},
+		{true, []uint8{0, 0, 255, 0}}, // row 1 is scanned right to left
+	}
+	for _, tt := range tests {
+		ai := newDitherer(t, DitherFloydSteinberg)
+		ai.SetSerpentine(tt.serpentine)
+		img := grayImage(2, 2, 64)
+		ai.ditherImage(img)
+		for i, want := range tt.want {
+			if got := img.Pix[i*4]; got != want {
+				t.Errorf("serpentine %v: pixel %d = %d, want %d", tt.serpentine, i, got, want)
+			}
+		}
+	}
+}
+
+func TestDitherStrengthZero(t *testing.T) {
+	for d := DitherFloydSteinberg; d <= DitherBlueNoise; d++ {
+		for _, cd := range []ColorDepth{ColorDepth256, ColorDepth16, ColorDepthNone} {
+			ai := newDitherer(t, d)
+			if err := ai.SetColorDepth(cd); err != nil {
+				t.Fatal(err)
+			}
+			ai.SetDitherStrength(0)
+			img := testImage(32, 32)
+			ai.ditherImage(img)
+
+			// same as nearest colors (or brightness levels) of pixels
+			want := testImage(32, 32)
+			for i := 0; i < len(want.Pix); i += 4 {
+				c := want.Pix[i : i+3 : i+3]
+				if cd == ColorDepthNone {
+					c[0] = uint8(ai.brightnessLevel(float64(max(c[0], c[1], c[2]))) + 0.5)
+					c[1], c[2] = c[0], c[0]
+				} else {
+					q := ai.nearestColor(c[0], c[1], c[2])
+					c[0], c[1], c[2] = q.R, q.G, q.B
+				}
+			}
+			if !bytes.Equal(img.Pix, want.Pix) {
+				t.Errorf("dither %d, depth %d: strength 0 differs from nearest colors", d, cd)
+			}
+		}
+	}
+}
+
+func TestDitherOnLoad(t *testing.T) {
+	// pixels are dithered by SetImage, before they are sampled to ANSI-pixels
+	zero := 0.0
+	tests := []struct {
+		name     string
+		d        Dither
+		strength *float64
+		want     float64
+	}{
+		{"no dither", NoDither, nil, 0},
+		{"default strength", DitherFloydSteinberg, nil, 0.25}, // full strength
+		{"zero strength", DitherFloydSteinberg, &zero, 0},
+	}
+	for _, tt := range tests {
+		opts := Options{DitheringMode: DitheringWithBraille, ColorDepth: ColorDepthNone, Dither: tt.d, DitherStrength: tt.strength}
+		ai, err := loadImage(context.Background(), grayImage(64, 64, 64), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lit := 0
+		for y := 0; y < ai.Height(); y++ {
+			for x := 0; x < ai.Width(); x++ {
+				if p, _ := ai.GetAt(y, x); p.Brightness > 127 {
+					lit++
+				}
+			}
+		}
+		if got := float64(lit) / float64(ai.Height()*ai.Width()); math.Abs(got-tt.want) > 0.02 {
+			t.Errorf("%s: %.3f of braille dots lit, want %.3f", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBayerMatrix(t *testing.T) {
+	tests := []struct {
+		size  int
+		ranks []int
+	}{
+		{2, []int{
+			0, 2,
+			3, 1,
+		}},
+		{4, []int{
+			0, 8, 2, 10,
+			12, 4, 14, 6,
+			3, 11, 1, 9,
+			15, 7, 13, 5,
+		}},
+	}
+	for _, tt := range tests {
+		tm := bayerMatrix(tt.size)
+		for i, r := range tt.ranks {
+			y, x := i/tt.size, i%tt.size
+			want := (float64(r)+0.5)/float64(len(tt.ranks)) - 0.5
+			if got := tm.at(y+tt.size, x+2*tt.size); got != want { // tiled
+				t.Errorf("Bayer %dx%d: threshold (%d,%d) = %v, want %v", tt.size, tt.size, y, x, got, want)
+			}
+		}
+	}
+}
+
+func TestOrderedMatrixRanks(t *testing.T) {
+	// every rank is used once, so thresholds are uniform in -0.5 to 0.5 range
+	for d, tm := range thresholdMatrices {
+		seen := make([]bool, len(tm.values))
+		for _, v := range tm.values {
+			r := int(math.Round((v+0.5)*float64(len(tm.values)) - 0.5))
+			if r < 0 || r >= len(seen) || seen[r] {
+				t.Errorf("dither %d: threshold %v has an invalid or repeated rank", d, v)
+				continue
+			}
+			seen[r] = true
+		}
+	}
+}
+
+func TestOrderedFlatGray(t *testing.T) {
+	// level 64 with threshold 127 lights the pixels with thresholds above 63/255 (0.247)
+	tests := []struct {
+		d        Dither
+		want     float64
+		wantHalf float64 // with half strength
+	}{
+		{DitherBayer2x2, 1.0 / 4, 0},             // rank 3 of 4
+		{DitherBayer4x4, 4.0 / 16, 0},            // ranks 12-15 of 16
+		{DitherBayer8x8, 16.0 / 64, 0},           // ranks 48-63 of 64
+		{DitherBlueNoise, 65.0 / 256, 2.0 / 256}, // ranks 191-255 of 256 (254-255 with half strength)
+	}
+	for _, tt := range tests {
+		ai := newDitherer(t, tt.d)
+		img := grayImage(64, 64, 64)
+		ai.ditherImage(img)
+		if got := litFraction(img); got != tt.want {
+			t.Errorf("dither %d: %.4f of pixels lit, want %.4f", tt.d, got, tt.want)
+		}
+
+		// half strength halves the thresholds, so fewer of them reach 63/255
+		ai.SetDitherStrength(0.5)
+		img = grayImage(64, 64, 64)
+		ai.ditherImage(img)
+		if got := litFraction(img); got != tt.wantHalf {
+			t.Errorf("dither %d, strength 0.5: %.4f of pixels lit, want %.4f", tt.d, got, tt.wantHalf)
+		}
+	}
+}
+
+func TestOrderedStable(t *testing.T) {
+	// each pixel does not depend on the others, so changes in a frame do not spread
+	for d := DitherBayer2x2; d <= DitherBlueNoise; d++ {
+		ai := newDitherer(t, d)
+		if err := ai.SetColorDepth(ColorDepth16); err != nil {
+			t.Fatal(err)
+		}
+		a, b := testImage(32, 32), testImage(32, 32)
+		b.SetRGBA(10, 10, color.RGBA{0xff, 0xff, 0xff, 0xff})
+		ai.ditherImage(a)
+		ai.ditherImage(b)
+		for y := 0; y < 32; y++ {
+			for x := 0; x < 32; x++ {
+				if (y != 10 || x != 10) && a.RGBAAt(x, y) != b.RGBAAt(x, y) {
+					t.Errorf("dither %d: pixel (%d,%d) changed by another pixel", d, y, x)
+				}
+			}
+		}
+	}
+}
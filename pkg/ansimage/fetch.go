@@ -0,0 +1,194 @@
+//       ___  _____  ____
+//      / _ \/  _/ |/_/ /____ ______ _
+//     / ___// /_>  </ __/ -_) __/  ' \
+//    /_/  /___/_/|_|\__/\__/_/ /_/_/_/
+//
+//    Copyright 2017 Eliuk Blau
+//
+//    This Source Code Form is subject to the terms of the Mozilla Public
+//    License, v. 2.0. If a copy of the MPL was not distributed with this
+//    file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package ansimage
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+)
+
+// Fetcher defaults (used when its fields are zero)
+const (
+	DefaultFetchTimeout  = 30 * time.Second
+	DefaultFetchMaxBytes = 64 << 20 // 64 MiB
+	DefaultFetchBackoff  = 500 * time.Millisecond
+	DefaultUserAgent     = "ansimage (+https://github.com/eliukblau/pixterm)"
+)
+
+var (
+	// ErrImageTooLarge occurs when downloaded image data is bigger than the max bytes of Fetcher.
+	ErrImageTooLarge = errors.New("ANSImage: image is too large")
+
+	// ErrNotAnImage occurs when downloaded data is not an image (e.g. an HTML page).
+	ErrNotAnImage = errors.New("ANSImage: downloaded data is not an image")
+)
+
+// FetchError occurs when an image download fails. It carries the URL and the HTTP status
+// of the response (empty if there is no response), and wraps the cause of failure:
+// ErrImageDownloadFailed if status is not "200 OK", ErrImageTooLarge, ErrNotAnImage,
+// or the error of HTTP client.
+type FetchError struct {
+	URL        string
+	StatusCode int
+	Status     string
+	Err        error
+}
+
+func (e *FetchError) Error() string {
+	if e.Status != "" {
+		return fmt.Sprintf("%s: %s (%s)", e.Err, e.URL, e.Status)
+	}
+	return fmt.Sprintf("%s: %s", e.Err, e.URL)
+}
+
+func (e *FetchError) Unwrap() error {
+	return e.Err
+}
+
+// Fetcher downloads images over HTTP/HTTPS. Zero value is ready to use with default settings.
+type Fetcher struct {
+	Client    *http.Client  // HTTP client (optional, default: http.DefaultClient)
+	Timeout   time.Duration // timeout of each attempt (optional, default: DefaultFetchTimeout)
+	MaxBytes  int64         // max size of image data (optional, default: DefaultFetchMaxBytes; <0 is unlimited)
+	UserAgent string        // User-Agent header, unless Header has one (optional, default: DefaultUserAgent)
+	Header    http.Header   // extra headers of requests (optional)
+	Retries   int           // retries of failed attempts by network errors or 408, 429 and 5xx status (optional)
+	Backoff   time.Duration // delay before first retry, doubled after each one (optional, default: DefaultFetchBackoff)
+	Cache     *Cache        // on-disk cache of downloaded images (optional, default: no cache)
+}
+
+// fetchResult is the result of a download: image data and headers of the response,
+// or only its headers if image was not modified since the cached one.
+type fetchResult struct {
+	data        []byte
+	header      http.Header
+	notModified bool
+}
+
+// DefaultFetcher is the Fetcher used to load images from URLs when Options have no Fetcher.
+var DefaultFetcher = &Fetcher{Retries: 2}
+
+// Fetch downloads the image data of an URL, or gets it from cache (if Fetcher has one).
+// The context cancels the download and its retries. Failures are returned as *FetchError.
+func (f *Fetcher) Fetch(ctx context.Context, url string) ([]byte, error) {
+	if f.Cache != nil {
+		return f.Cache.fetch(ctx, f, url)
+	}
+	res, err := f.get(ctx, url, nil)
+	return res.data, err
+}
+
+// get downloads an URL with extra headers of request (e.g. conditional ones), retrying failed attempts.
+func (f *Fetcher) get(ctx context.Context, url string, header http.Header) (fetchResult, error) {
+	backoff := cmp.Or(f.Backoff, DefaultFetchBackoff)
+	for retry := 0; ; retry++ {
+		res, temporary, err := f.fetch(ctx, url, header)
+		if err == nil || !temporary || retry >= f.Retries {
+			return res, err
+		}
+
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return fetchResult{}, &FetchError{URL: url, Err: ctx.Err()}
+		}
+	}
+}
+
+// fetch makes an attempt to download the image data of an URL,
+// and reports if the error is temporary (so it can be retried).
+func (f *Fetcher) fetch(ctx context.Context, url string, header http.Header) (fetchResult, bool, error) {
+	attemptCtx, cancel := context.WithTimeout(ctx, cmp.Or(f.Timeout, DefaultFetchTimeout))
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(attemptCtx, http.MethodGet, url, nil)
+	if err != nil {
+		return fetchResult{}, false, &FetchError{URL: url, Err: err}
+	}
+	for name, values := range f.Header {
+		req.Header[http.CanonicalHeaderKey(name)] = values
+	}
+	for name, values := range header {
+		req.Header[http.CanonicalHeaderKey(name)] = values
+	}
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", cmp.Or(f.UserAgent, DefaultUserAgent))
+	}
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", "image/*")
+	}
+
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		if urlErr, ok := err.(*neturl.Error); ok {
+			err = urlErr.Err // FetchError has the URL
+		}
+		return fetchResult{}, ctx.Err() == nil, &FetchError{URL: url, Err: err} // network errors and attempt timeouts are temporary
+	}
+	defer res.Body.Close()
+
+	fail := func(err error) *FetchError {
+		return &FetchError{URL: url, StatusCode: res.StatusCode, Status: res.Status, Err: err}
+	}
+
+	if res.StatusCode == http.StatusNotModified && len(header) > 0 {
+		return fetchResult{header: res.Header, notModified: true}, false, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, io.LimitReader(res.Body, 4<<10)) // reuses connection for retries
+		temporary := res.StatusCode == http.StatusRequestTimeout ||
+			res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+		return fetchResult{}, temporary, fail(ErrImageDownloadFailed)
+	}
+
+	maxBytes := cmp.Or(f.MaxBytes, DefaultFetchMaxBytes)
+	if maxBytes > 0 && res.ContentLength > maxBytes {
+		return fetchResult{}, false, fail(ErrImageTooLarge)
+	}
+	var body io.Reader = res.Body
+	if maxBytes > 0 {
+		body = io.LimitReader(res.Body, maxBytes+1)
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return fetchResult{}, ctx.Err() == nil, fail(err)
+	}
+	if maxBytes > 0 && int64(len(data)) > maxBytes {
+		return fetchResult{}, false, fail(ErrImageTooLarge)
+	}
+
+	// data is sniffed because servers can answer with HTML pages, but unknown binary data
+	// (e.g. TIFF images) is accepted if Content-Type header says it is an image or binary data
+	if sniffed := http.DetectContentType(data); !strings.HasPrefix(sniffed, "image/") {
+		contentType := res.Header.Get("Content-Type")
+		binary := contentType == "" ||
+			strings.HasPrefix(contentType, "image/") || strings.HasPrefix(contentType, "application/octet-stream")
+		if sniffed != "application/octet-stream" || !binary {
+			return fetchResult{}, false, fail(ErrNotAnImage)
+		}
+	}
+
+	return fetchResult{data: data, header: res.Header}, false, nil
+}
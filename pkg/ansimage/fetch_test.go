@@ -0,0 +1,213 @@
+//       ___  _____  ____
+//      / _ \/  _/ |/_/ /____ ______ _
+//     / ___// /_>  </ __/ -_) __/  ' \
+//    /_/  /___/_/|_|\__/\__/_/ /_/_/_/
+//
+//    Copyright 2017 Eliuk Blau
+//
+//    This Source Code Form is subject to the terms of the Mozilla Public
+//    License, v. 2.0. If a copy of the MPL was not distributed with this
+//    file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package ansimage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testPNG returns the PNG data of a test image.
+func testPNG(tb testing.TB) []byte {
+	tb.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage(16, 16)); err != nil {
+		tb.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testServer starts an HTTP server with the handler, counting its requests.
+func testServer(tb testing.TB, handler func(w http.ResponseWriter, r *http.Request, n int)) (*httptest.Server, *atomic.Int32) {
+	tb.Helper()
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r, int(requests.Add(1)))
+	}))
+	tb.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func TestFetchUserAgent(t *testing.T) {
+	data := testPNG(t)
+	var userAgent atomic.Value
+	srv, _ := testServer(t, func(w http.ResponseWriter, r *http.Request, n int) {
+		userAgent.Store(r.UserAgent())
+		w.Write(data)
+	})
+
+	tests := []struct {
+		name    string
+		fetcher *Fetcher
+		want    string
+	}{
+		{"default", &Fetcher{}, DefaultUserAgent},
+		{"field", &Fetcher{UserAgent: "field/1.0"}, "field/1.0"},
+		{"header", &Fetcher{UserAgent: "field/1.0", Header: http.Header{"User-Agent": {"header/1.0"}}}, "header/1.0"},
+		{"lowercase header", &Fetcher{Header: http.Header{"user-agent": {"header/1.0"}}}, "header/1.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.fetcher.Fetch(context.Background(), srv.URL); err != nil {
+				t.Fatal(err)
+			}
+			if got := userAgent.Load(); got != tt.want {
+				t.Errorf("User-Agent = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchRetries(t *testing.T) {
+	data := testPNG(t)
+	for _, status := range []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusRequestTimeout} {
+		srv, requests := testServer(t, func(w http.ResponseWriter, r *http.Request, n int) {
+			if n <= 2 {
+				w.WriteHeader(status)
+				return
+			}
+			w.Write(data)
+		})
+
+		f := &Fetcher{Retries: 2, Backoff: time.Millisecond}
+		got, err := f.Fetch(context.Background(), srv.URL)
+		if err != nil {
+			t.Fatalf("status %d: %v", status, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("status %d: Fetch returned %d bytes, want %d", status, len(got), len(data))
+		}
+		if n := requests.Load(); n != 3 {
+			t.Errorf("status %d: %d requests, want 3", status, n)
+		}
+	}
+}
+
+func TestFetchRetriesExhausted(t *testing.T) {
+	srv, requests := testServer(t, func(w http.ResponseWriter, r *http.Request, n int) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	f := &Fetcher{Retries: 2, Backoff: time.Millisecond}
+	_, err := f.Fetch(context.Background(), srv.URL)
+	var fe *FetchError
+	if !errors.As(err, &fe) || fe.StatusCode != http.StatusBadGateway || !errors.Is(err, ErrImageDownloadFailed) {
+		t.Fatalf("Fetch error = %v, want FetchError with status %d", err, http.StatusBadGateway)
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("%d requests, want 3", n)
+	}
+}
+
+func TestFetchNotFound(t *testing.T) {
+	srv, requests := testServer(t, func(w http.ResponseWriter, r *http.Request, n int) {
+		http.NotFound(w, r)
+	})
+
+	url := srv.URL + "/missing.png"
+	f := &Fetcher{Retries: 2, Backoff: time.Millisecond}
+	_, err := f.Fetch(context.Background(), url)
+
+	var fe *FetchError
+	if !errors.As(err, &fe) {
+		t.Fatalf("Fetch error = %v, want *FetchError", err)
+	}
+	if fe.URL != url || fe.StatusCode != http.StatusNotFound || fe.Status != "404 Not Found" {
+		t.Errorf("FetchError = %+v, want URL %q and status 404", fe, url)
+	}
+	if !errors.Is(err, ErrImageDownloadFailed) {
+		t.Errorf("Fetch error = %v, want %v", err, ErrImageDownloadFailed)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("%d requests, want 1 (no retries)", n)
+	}
+}
+
+func TestFetchMaxBytes(t *testing.T) {
+	data := testPNG(t)
+	for _, chunked := range []bool{false, true} {
+		srv, _ := testServer(t, func(w http.ResponseWriter, r *http.Request, n int) {
+			if chunked {
+				w.(http.Flusher).Flush() // sends headers without Content-Length
+			}
+			w.Write(data)
+		})
+
+		f := &Fetcher{MaxBytes: int64(len(data)) - 1}
+		_, err := f.Fetch(context.Background(), srv.URL)
+		var fe *FetchError
+		if !errors.As(err, &fe) || !errors.Is(err, ErrImageTooLarge) || fe.StatusCode != http.StatusOK {
+			t.Errorf("chunked %v: Fetch error = %v, want %v", chunked, err, ErrImageTooLarge)
+		}
+
+		f.MaxBytes = int64(len(data))
+		if got, err := f.Fetch(context.Background(), srv.URL); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("chunked %v: Fetch of max bytes = %d bytes, %v", chunked, len(got), err)
+		}
+	}
+}
+
+func TestFetchNotAnImage(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+		wantErr     error
+	}{
+		{"html", "text/html; charset=utf-8", []byte("<!DOCTYPE html><html><body>Not Found</body></html>"), ErrNotAnImage},
+		{"html as image", "image/png", []byte("<html><body>Error</body></html>"), ErrNotAnImage},
+		{"unknown image", "image/tiff", []byte("II*\x00\x08\x00\x00\x00\xff\xfe"), nil},
+		{"unknown binary", "text/plain", []byte("\x00\x01\x02\x03\xff\xfe"), ErrNotAnImage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _ := testServer(t, func(w http.ResponseWriter, r *http.Request, n int) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.Write(tt.body)
+			})
+
+			_, err := (&Fetcher{}).Fetch(context.Background(), srv.URL)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Fetch error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFetchCancelBackoff(t *testing.T) {
+	srv, requests := testServer(t, func(w http.ResponseWriter, r *http.Request, n int) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	f := &Fetcher{Retries: 5, Backoff: time.Hour}
+
+	start := time.Now()
+	_, err := f.Fetch(ctx, srv.URL)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Fetch error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Fetch returned after %v, want it canceled in backoff", elapsed)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("%d requests, want 1", n)
+	}
+}
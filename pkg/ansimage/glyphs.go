@@ -0,0 +1,229 @@
+//       ___  _____  ____
+//      / _ \/  _/ |/_/ /____ ______ _
+//     / ___// /_>  </ __/ -_) __/  ' \
+//    /_/  /___/_/|_|\__/\__/_/ /_/_/_/
+//
+//    Copyright 2017 Eliuk Blau
+//
+//    This Source Code Form is subject to the terms of the Mozilla Public
+//    License, v. 2.0. If a copy of the MPL was not distributed with this
+//    file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package ansimage
+
+import (
+	"math/bits"
+	"strings"
+)
+
+// ANSImage glyph sets (glyphs dithering mode), that can be combined:
+// ASCII (7-bit printable chars),
+// box (box drawing lines),
+// blocks (block elements),
+// geometric (geometric shapes, some fonts render them wider than a cell),
+// all (every glyph set).
+const (
+	GlyphSetASCII = GlyphSet(1 << iota)
+	GlyphSetBox
+	GlyphSetBlocks
+	GlyphSetGeometric
+
+	GlyphSetAll = GlyphSetASCII | GlyphSetBox | GlyphSetBlocks | GlyphSetGeometric
+)
+
+// GlyphSetDefault are the glyph sets used by ANSImage unless other ones are set.
+const GlyphSetDefault = GlyphSetASCII | GlyphSetBox | GlyphSetBlocks
+
+// GlyphSet type is used for glyph set constants (glyphs dithering mode).
+type GlyphSet uint8
+
+// glyphShape is a glyph with its coverage bitmap in a cell of BlockSizeY x BlockSizeX pixels.
+// The bit (BlockSizeX*y + x) of the mask is set if the glyph covers the pixel (y,x).
+type glyphShape struct {
+	set   GlyphSet
+	glyph rune
+	mask  uint32
+}
+
+// glyphMask returns the coverage mask of a glyph from its bitmap, with BlockSizeY rows
+// of BlockSizeX pixels ('#' for covered pixels) separated by spaces.
+func glyphMask(bitmap string) uint32 {
+	var mask uint32
+	for i, c := range strings.ReplaceAll(bitmap, " ", "") {
+		if c == '#' {
+			mask |= 1 << i
+		}
+	}
+	return mask
+}
+
+// glyphShapes is the coverage table of all the glyphs that can be matched in glyphs dithering mode.
+var glyphShapes = []glyphShape{
+	{GlyphSetAll, ' ', glyphMask(".... .... .... .... .... .... .... ....")},
+
+	{GlyphSetASCII, '.', glyphMask(".... .... .... .... .... .... .#.. ....")},
+	{GlyphSetASCII, ',', glyphMask(".... .... .... .... .... .... .#.. #...")},
+	{GlyphSetASCII, ':', glyphMask(".... .... .#.. .... .... .#.. .... ....")},
+	{GlyphSetASCII, ';', glyphMask(".... .... .#.. .... .... .#.. #... ....")},
+	{GlyphSetASCII, '\'', glyphMask(".#.. .#.. .... .... .... .... .... ....")},
+	{GlyphSetASCII, '"', glyphMask("#.#. #.#. .... .... .... .... .... ....")},
+	{GlyphSetASCII, '`', glyphMask("#... .#.. .... .... .... .... .... ....")},
+	{GlyphSetASCII, '-', glyphMask(".... .... .... ###. .... .... .... ....")},
+	{GlyphSetASCII, '_', glyphMask(".... .... .... .... .... .... .... ####")},
+	{GlyphSetASCII, '=', glyphMask(".... .... ###. .... ###. .... .... ....")},
+	{GlyphSetASCII, '+', glyphMask(".... .#.. .#.. ###. .#.. .#.. .... ....")},
+	{GlyphSetASCII, '*', glyphMask(".... #.#. .#.. ###. .#.. #.#. .... ....")},
+	{GlyphSetASCII, '^', glyphMask(".#.. #.#. .... .... .... .... .... ....")},
+	{GlyphSetASCII, '|', glyphMask(".#.. .#.. .#.. .#.. .#.. .#.. .#.. .#..")},
+	{GlyphSetASCII, '/', glyphMask("...# ...# ..#. ..#. .#.. .#.. #... #...")},
+	{GlyphSetASCII, '\\', glyphMask("#... #... .#.. .#.. ..#. ..#. ...# ...#")},
+	{GlyphSetASCII, '(', glyphMask("..#. .#.. #... #... #... #... .#.. ..#.")},
+	{GlyphSetASCII, ')', glyphMask(".#.. ..#. ...# ...# ...# ...# ..#. .#..")},
+	{GlyphSetASCII, '[', glyphMask("##.. #... #... #... #... #... #... ##..")},
+	{GlyphSetASCII, ']', glyphMask("..## ...# ...# ...# ...# ...# ...# ..##")},
+	{GlyphSetASCII, '<', glyphMask(".... ...# ..#. .#.. #... .#.. ..#. ...#")},
+	{GlyphSetASCII, '>', glyphMask(".... #... .#.. ..#. ...# ..#. .#.. #...")},
+	{GlyphSetASCII, 'o', glyphMask(".... .... .##. #..# #..# .##. .... ....")},
+	{GlyphSetASCII, 'x', glyphMask(".... .... #..# .##. .##. #..# .... ....")},
+	{GlyphSetASCII, 'n', glyphMask(".... .... ###. #..# #..# #..# #..# ....")},
+	{GlyphSetASCII, 'u', glyphMask(".... .... #..# #..# #..# #..# .### ....")},
+	{GlyphSetASCII, 'O', glyphMask(".##. #..# #..# #..# #..# #..# .##. ....")},
+	{GlyphSetASCII, 'H', glyphMask("#..# #..# #..# #### #..# #..# #..# ....")},
+	{GlyphSetASCII, 'U', glyphMask("#..# #..# #..# #..# #..# #..# .##. ....")},
+	{GlyphSetASCII, 'T', glyphMask("###. .#.. .#.. .#.. .#.. .#.. .#.. ....")},
+	{GlyphSetASCII, 'L', glyphMask("#... #... #... #... #... #... ###. ....")},
+	{GlyphSetASCII, 'X', glyphMask("#..# #..# .##. .##. .##. .##. #..# #..#")},
+	{GlyphSetASCII, '#', glyphMask("#.#. #.#. #### #.#. #### #.#. #.#. ....")},
+	{GlyphSetASCII, '@', glyphMask(".##. #..# #.## #.## #.## #... .### ....")},
+
+	{GlyphSetBox, '─', glyphMask(".... .... .... #### .... .... .... ....")},
+	{GlyphSetBox, '━', glyphMask(".... .... .... #### #### .... .... ....")},
+	{GlyphSetBox, '│', glyphMask(".#.. .#.. .#.. .#.. .#.. .#.. .#.. .#..")},
+	{GlyphSetBox, '┃', glyphMask(".##. .##. .##. .##. .##. .##. .##. .##.")},
+	{GlyphSetBox, '┌', glyphMask(".... .... .... .### .#.. .#.. .#.. .#..")},
+	{GlyphSetBox, '┐', glyphMask(".... .... .... ##.. .#.. .#.. .#.. .#..")},
+	{GlyphSetBox, '└', glyphMask(".#.. .#.. .#.. .### .... .... .... ....")},
+	{GlyphSetBox, '┘', glyphMask(".#.. .#.. .#.. ##.. .... .... .... ....")},
+	{GlyphSetBox, '├', glyphMask(".#.. .#.. .#.. .### .#.. .#.. .#.. .#..")},
+	{GlyphSetBox, '┤', glyphMask(".#.. .#.. .#.. ##.. .#.. .#.. .#.. .#..")},
+	{GlyphSetBox, '┬', glyphMask(".... .... .... #### .#.. .#.. .#.. .#..")},
+	{GlyphSetBox, '┴', glyphMask(".#.. .#.. .#.. #### .... .... .... ....")},
+	{GlyphSetBox, '┼', glyphMask(".#.. .#.. .#.. #### .#.. .#.. .#.. .#..")},
+	{GlyphSetBox, '═', glyphMask(".... .... #### .... #### .... .... ....")},
+	{GlyphSetBox, '║', glyphMask("#.#. #.#. #.#. #.#. #.#. #.#. #.#. #.#.")},
+	{GlyphSetBox, '╱', glyphMask("...# ...# ..#. ..#. .#.. .#.. #... #...")},
+	{GlyphSetBox, '╲', glyphMask("#... #... .#.. .#.. ..#. ..#. ...# ...#")},
+	{GlyphSetBox, '╳', glyphMask("#..# #..# .##. .##. .##. .##. #..# #..#")},
+
+	{GlyphSetBlocks, '█', glyphMask("#### #### #### #### #### #### #### ####")},
+	{GlyphSetBlocks, '▀', glyphMask("#### #### #### #### .... .... .... ....")},
+	{GlyphSetBlocks, '▄', glyphMask(".... .... .... .... #### #### #### ####")},
+	{GlyphSetBlocks, '▌', glyphMask("##.. ##.. ##.. ##.. ##.. ##.. ##.. ##..")},
+	{GlyphSetBlocks, '▐', glyphMask("..## ..## ..## ..## ..## ..## ..## ..##")},
+	{GlyphSetBlocks, '▔', glyphMask("#### .... .... .... .... .... .... ....")},
+	{GlyphSetBlocks, '▁', glyphMask(".... .... .... .... .... .... .... ####")},
+	{GlyphSetBlocks, '▂', glyphMask(".... .... .... .... .... .... #### ####")},
+	{GlyphSetBlocks, '▃', glyphMask(".... .... .... .... .... #### #### ####")},
+	{GlyphSetBlocks, '▅', glyphMask(".... .... .... #### #### #### #### ####")},
+	{GlyphSetBlocks, '▆', glyphMask(".... .... #### #### #### #### #### ####")},
+	{GlyphSetBlocks, '▇', glyphMask(".... #### #### #### #### #### #### ####")},
+	{GlyphSetBlocks, '▎', glyphMask("#... #... #... #... #... #... #... #...")},
+	{GlyphSetBlocks, '▊', glyphMask("###. ###. ###. ###. ###. ###. ###. ###.")},
+	{GlyphSetBlocks, '▘', glyphMask("##.. ##.. ##.. ##.. .... .... .... ....")},
+	{GlyphSetBlocks, '▝', glyphMask("..## ..## ..## ..## .... .... .... ....")},
+	{GlyphSetBlocks, '▖', glyphMask(".... .... .... .... ##.. ##.. ##.. ##..")},
+	{GlyphSetBlocks, '▗', glyphMask(".... .... .... .... ..## ..## ..## ..##")},
+	{GlyphSetBlocks, '▚', glyphMask("##.. ##.. ##.. ##.. ..## ..## ..## ..##")},
+	{GlyphSetBlocks, '▞', glyphMask("..## ..## ..## ..## ##.. ##.. ##.. ##..")},
+	{GlyphSetBlocks, '▛', glyphMask("#### #### #### #### ##.. ##.. ##.. ##..")},
+	{GlyphSetBlocks, '▜', glyphMask("#### #### #### #### ..## ..## ..## ..##")},
+	{GlyphSetBlocks, '▙', glyphMask("##.. ##.. ##.. ##.. #### #### #### ####")},
+	{GlyphSetBlocks, '▟', glyphMask("..## ..## ..## ..## #### #### #### ####")},
+
+	{GlyphSetGeometric, '■', glyphMask(".... .... #### #### #### #### .... ....")},
+	{GlyphSetGeometric, '▪', glyphMask(".... .... .... .##. .##. .... .... ....")},
+	{GlyphSetGeometric, '●', glyphMask(".... .##. #### #### #### .##. .... ....")},
+	{GlyphSetGeometric, '◆', glyphMask(".... .##. .##. #### #### .##. .##. ....")},
+	{GlyphSetGeometric, '▲', glyphMask(".... .... .##. .##. #### #### .... ....")},
+	{GlyphSetGeometric, '▼', glyphMask(".... .... #### #### .##. .##. .... ....")},
+	{GlyphSetGeometric, '◀', glyphMask(".... ...# ..## .### .### ..## ...# ....")},
+	{GlyphSetGeometric, '▶', glyphMask(".... #... ##.. ###. ###. ##.. #... ....")},
+	{GlyphSetGeometric, '◢', glyphMask("...# ...# ..## ..## .### .### #### ####")},
+	{GlyphSetGeometric, '◣', glyphMask("#... #... ##.. ##.. ###. ###. #### ####")},
+	{GlyphSetGeometric, '◤', glyphMask("#### #### ###. ###. ##.. ##.. #... #...")},
+	{GlyphSetGeometric, '◥', glyphMask("#### #### .### .### ..## ..## ...# ...#")},
+}
+
+// GlyphSet gets the glyph sets of ANSImage (glyphs dithering mode).
+func (ai *ANSImage) GlyphSet() GlyphSet {
+	return ai.glyphset
+}
+
+// SetGlyphSet sets the glyph sets that ANSImage matches against the pixels of each cell
+// (glyphs dithering mode). Zero value means GlyphSetDefault.
+func (ai *ANSImage) SetGlyphSet(gs GlyphSet) {
+	if gs&GlyphSetAll == 0 {
+		gs = GlyphSetDefault
+	}
+	ai.glyphset = gs
+
+	ai.glyphs, ai.glyphMasks = nil, nil
+	for _, shape := range glyphShapes {
+		if shape.set&gs != 0 {
+			ai.glyphs = append(ai.glyphs, shape)
+			ai.glyphMasks = append(ai.glyphMasks, shape.mask)
+		}
+	}
+}
+
+// encodeGlyphCell returns the ANSI-compatible string form of the glyph cell.
+// The glyph that best matches the pixels of the cell is chosen from the coverage table:
+// with color, the one with the least squared error using the mean colors of the covered (fg)
+// and uncovered (bg) pixels; without color, the one with the least pixels different from
+// the brightness thresholded cell. If background color is disabled, the uncovered pixels
+// take the background color.
+func (ai *ANSImage) encodeGlyphCell(rc *RenderContext, cell Cell) string {
+	var pixels [BlockSizeY * BlockSizeX]ANSIpixel
+	for dy := 0; dy < BlockSizeY; dy++ {
+		for dx := 0; dx < BlockSizeX; dx++ {
+			pixels[dy*BlockSizeX+dx] = cell.At(dy, dx)
+		}
+	}
+
+	// WITHOUT COLOR
+	if ai.colordepth == ColorDepthNone {
+		threshold := ai.cellThreshold(cell, BlockSizeY, BlockSizeX)
+		var bitmap uint32
+		for i, p := range pixels {
+			isLit := p.Brightness > threshold
+			if ai.inverted {
+				isLit = !isLit
+			}
+			if isLit {
+				bitmap |= 1 << i
+			}
+		}
+
+		best, bestDiff := ' ', len(pixels)+1
+		for _, shape := range ai.glyphs {
+			if ai.asciiOnly && shape.set&GlyphSetASCII == 0 {
+				continue
+			}
+			if diff := bits.OnesCount32(bitmap ^ shape.mask); diff < bestDiff {
+				best, bestDiff = shape.glyph, diff
+			}
+		}
+		return string(best)
+	}
+
+	best, fg, bg := ai.bestSplit(pixels[:], ai.glyphMasks, rc.DisableBgColor)
+
+	bgColor := CellRGB(bg[0], bg[1], bg[2])
+	if rc.DisableBgColor {
+		bgColor = CellColor{}
+	}
+	if ai.glyphMasks[best] == 0 {
+		return rc.Paint(bgColor, CellColor{}, " ")
+	}
+	return rc.Paint(bgColor, CellRGB(fg[0], fg[1], fg[2]), string(ai.glyphs[best].glyph))
+}
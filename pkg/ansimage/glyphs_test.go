@@ -0,0 +1,73 @@
+//       ___  _____  ____
+//      / _ \/  _/ |/_/ /____ ______ _
+//     / ___// /_>  </ __/ -_) __/  ' \
+//    /_/  /___/_/|_|\__/\__/_/ /_/_/_/
+//
+//    Copyright 2017 Eliuk Blau
+//
+//    This Source Code Form is subject to the terms of the Mozilla Public
+//    License, v. 2.0. If a copy of the MPL was not distributed with this
+//    file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package ansimage
+
+import (
+	"strings"
+	"testing"
+)
+
+// glyphCell returns the rows of a glyph cell from its bitmap, like glyphMask,
+// with lit pixels ('#') in white and the other ones in black.
+func glyphCell(bitmap string) []string {
+	bitmap = strings.NewReplacer("#", "f", ".", "0").Replace(bitmap)
+	return strings.Fields(bitmap)
+}
+
+func TestGlyphCoverage(t *testing.T) {
+	tests := []struct {
+		name      string
+		bitmap    string
+		glyphset  GlyphSet
+		asciiOnly bool
+		want      string
+	}{
+		{"blank", ".... .... .... .... .... .... .... ....", GlyphSetDefault, false, " "},
+		{"upper half", "#### #### #### #### .... .... .... ....", GlyphSetDefault, false, "▀"},
+		{"upper half in ASCII", "#### #### #### #### .... .... .... ....", GlyphSetDefault, true, "\""},
+		{"vertical line", ".#.. .#.. .#.. .#.. .#.. .#.. .#.. .#..", GlyphSetDefault, false, "|"}, // ASCII glyph is first
+		{"vertical line in box", ".#.. .#.. .#.. .#.. .#.. .#.. .#.. .#..", GlyphSetBox, false, "│"},
+		{"letter H", "#..# #..# #..# #### #..# #..# #..# ....", GlyphSetDefault, false, "H"},
+		{"noisy letter H", "#..# #..# #.## #### #..# #..# #..# ....", GlyphSetDefault, false, "H"},
+		{"left half", "##.. ##.. ##.. ##.. ##.. ##.. ##.. ##..", GlyphSetDefault, false, "▌"},
+		{"triangle", "...# ...# ..## ..## .### .### #### ####", GlyphSetGeometric, false, "◢"},
+		{"full", "#### #### #### #### #### #### #### ####", GlyphSetBlocks, false, "█"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := Options{DitheringMode: DitheringWithGlyphs, ColorDepth: ColorDepthNone, ASCIIOnly: tt.asciiOnly, Threshold: 0x80}
+			ai := loadTestImage(t, 1, 1, opts) // only checks options
+			ai.SetGlyphSet(tt.glyphset)
+			if err := ai.SetImage(cellImage(glyphCell(tt.bitmap)...)); err != nil {
+				t.Fatal(err)
+			}
+			if got := ai.Renderer().EncodeCell(&RenderContext{Image: ai}, Cell{image: ai}); got != tt.want {
+				t.Errorf("glyph cell = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGlyphColors(t *testing.T) {
+	rows := []string{"RRRR", "RRRR", "RRRR", "RRRR", "BBBB", "BBBB", "BBBB", "BBBB"}
+	want := "\033[48;2;0;0;255m\033[38;2;255;0;0m▀"
+	if got := encodeFirstCell(t, cellImage(rows...), Options{DitheringMode: DitheringWithGlyphs}, RenderContext{}); got != want {
+		t.Errorf("glyph cell = %q, want %q", got, want)
+	}
+
+	// uncovered pixels take background color, so black pixels are uncovered
+	rows = []string{"0000", "0000", "0000", "GGGG", "0000", "0000", "0000", "0000"}
+	want = "\033[38;2;0;255;0m─"
+	if got := encodeFirstCell(t, cellImage(rows...), Options{DitheringMode: DitheringWithGlyphs}, RenderContext{DisableBgColor: true}); got != want {
+		t.Errorf("glyph cell without background = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,71 @@
+//       ___  _____  ____
+//      / _ \/  _/ |/_/ /____ ______ _
+//     / ___// /_>  </ __/ -_) __/  ' \
+//    /_/  /___/_/|_|\__/\__/_/ /_/_/_/
+//
+//    Copyright 2017 Eliuk Blau
+//
+//    This Source Code Form is subject to the terms of the Mozilla Public
+//    License, v. 2.0. If a copy of the MPL was not distributed with this
+//    file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package ansimage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+)
+
+// ITerm2Options is used to configure the output of iTerm2 inline images protocol.
+type ITerm2Options struct {
+	Name                string // file name shown by terminal (optional)
+	Cols                int    // width in cells (optional, default: image width)
+	Rows                int    // height in cells (optional, default: image height)
+	PreserveAspectRatio bool   // fit image in cells instead of stretch it
+}
+
+// RenderITerm2 returns the iTerm2 inline images protocol form of an image (usually scaled
+// with ScaleImage), re-encoded as PNG. Background color is used to fill when image has
+// transparency; if it has transparency too, the image is transmitted with its alpha channel.
+// (Nice info for iTerm2 inline images protocol - https://iterm2.com/documentation-images.html)
+func RenderITerm2(img image.Image, bg color.Color, opts ITerm2Options) string {
+	var buf bytes.Buffer
+	png.Encode(&buf, compositeImage(img, bg)) // never fails writing to memory
+	return RenderITerm2Data(buf.Bytes(), opts)
+}
+
+// RenderITerm2Data returns the iTerm2 inline images protocol form of image file data.
+// Data is passed through without decoding, so it must be in a format that terminal can decode.
+func RenderITerm2Data(data []byte, opts ITerm2Options) string {
+	args := []string{"inline=1", fmt.Sprintf("size=%d", len(data))}
+	if opts.Name != "" {
+		args = append(args, "name="+base64.StdEncoding.EncodeToString([]byte(opts.Name)))
+	}
+	if opts.Cols > 0 {
+		args = append(args, fmt.Sprintf("width=%d", opts.Cols))
+	}
+	if opts.Rows > 0 {
+		args = append(args, fmt.Sprintf("height=%d", opts.Rows))
+	}
+	if opts.PreserveAspectRatio {
+		args = append(args, "preserveAspectRatio=1")
+	} else {
+		args = append(args, "preserveAspectRatio=0")
+	}
+
+	return fmt.Sprintf(
+		"\033]1337;File=%s:%s\a",
+		strings.Join(args, ";"),
+		base64.StdEncoding.EncodeToString(data),
+	)
+}
+
+// DrawITerm2 writes the iTerm2 inline images protocol form of an image to standard output (terminal).
+func DrawITerm2(img image.Image, bg color.Color, opts ITerm2Options) {
+	fmt.Print(RenderITerm2(img, bg, opts))
+}
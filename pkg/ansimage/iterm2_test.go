@@ -0,0 +1,76 @@
+//       ___  _____  ____
+//      / _ \/  _/ |/_/ /____ ______ _
+//     / ___// /_>  </ __/ -_) __/  ' \
+//    /_/  /___/_/|_|\__/\__/_/ /_/_/_/
+//
+//    Copyright 2017 Eliuk Blau
+//
+//    This Source Code Form is subject to the terms of the Mozilla Public
+//    License, v. 2.0. If a copy of the MPL was not distributed with this
+//    file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package ansimage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestRenderITerm2Data(t *testing.T) {
+	data := []byte("GIF89a data")
+	b64 := base64.StdEncoding.EncodeToString(data)
+	tests := []struct {
+		name string
+		opts ITerm2Options
+		want string
+	}{
+		{"default", ITerm2Options{}, "\033]1337;File=inline=1;size=11;preserveAspectRatio=0:" + b64 + "\a"},
+		{
+			"all options",
+			ITerm2Options{Name: "cat.gif", Cols: 40, Rows: 12, PreserveAspectRatio: true},
+			"\033]1337;File=inline=1;size=11;name=Y2F0LmdpZg==;width=40;height=12;preserveAspectRatio=1:" + b64 + "\a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RenderITerm2Data(data, tt.opts); got != tt.want {
+				t.Errorf("RenderITerm2Data = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderITerm2(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	img.Set(1, 1, color.NRGBA{0x10, 0x20, 0x30, 0x80})
+
+	for _, bg := range []color.Color{color.Transparent, color.White} {
+		out := RenderITerm2(img, bg, ITerm2Options{Cols: 3})
+		m := regexp.MustCompile(`^\x1b]1337;File=inline=1;size=([0-9]+);width=3;preserveAspectRatio=0:([A-Za-z0-9+/=]+)\a$`).FindStringSubmatch(out)
+		if m == nil {
+			t.Fatalf("RenderITerm2 = %q, want an OSC 1337 inline file", out)
+		}
+		data, err := base64.StdEncoding.DecodeString(m[2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m[1] != strconv.Itoa(len(data)) {
+			t.Errorf("size = %s, want %d", m[1], len(data))
+		}
+
+		decoded, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := color.NRGBAModel.Convert(compositeImage(img, bg).At(1, 1))
+		if got := color.NRGBAModel.Convert(decoded.At(1, 1)); got != want {
+			t.Errorf("background %v: pixel = %v, want %v", bg, got, want)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+//       ___  _____  ____
+//      / _ \/  _/ |/_/ /____ ______ _
+//     / ___// /_>  </ __/ -_) __/  ' \
+//    /_/  /___/_/|_|\__/\__/_/ /_/_/_/
+//
+//    Copyright 2017 Eliuk Blau
+//
+//    This Source Code Form is subject to the terms of the Mozilla Public
+//    License, v. 2.0. If a copy of the MPL was not distributed with this
+//    file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package ansimage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+)
+
+// Kitty graphics protocol payload formats:
+// PNG (compressed, default),
+// RGBA (raw 32-bit pixels, faster to encode but bigger).
+const (
+	KittyFormatPNG = KittyFormat(iota)
+	KittyFormatRGBA
+)
+
+// kittyChunkSize is the maximum size of base64 payload in each escape code.
+const kittyChunkSize = 4096
+
+// KittyFormat type is used for kitty graphics protocol payload format constants.
+type KittyFormat uint8
+
+// KittyOptions is used to configure the output of kitty graphics protocol.
+type KittyOptions struct {
+	ID     uint32      // image ID, to replace or delete it later (optional)
+	Cols   int         // placement width in cells (optional, default: image width)
+	Rows   int         // placement height in cells (optional, default: image height)
+	Format KittyFormat // payload format
+}
+
+// RenderKitty returns the kitty graphics protocol form of an image (usually scaled with ScaleImage),
+// that transmits and displays it at cursor position. Background color is used to fill when image
+// has transparency; if it has transparency too, the image is transmitted with its alpha channel.
+// Returns ErrUnknownKittyFormat if payload format is invalid.
+// (Nice info for kitty graphics protocol - https://sw.kovidgoyal.net/kitty/graphics-protocol/)
+func RenderKitty(img image.Image, bg color.Color, opts KittyOptions) (string, error) {
+	if opts.Format > KittyFormatRGBA {
+		return "", ErrUnknownKittyFormat // before compositing image
+	}
+
+	rgba := compositeImage(img, bg)
+
+	var (
+		control string
+		payload []byte
+	)
+	switch opts.Format {
+	case KittyFormatPNG:
+		var buf bytes.Buffer
+		png.Encode(&buf, rgba) // never fails writing to memory
+		control = "a=T,f=100"
+		payload = buf.Bytes()
+	case KittyFormatRGBA:
+		control = fmt.Sprintf("a=T,f=32,s=%d,v=%d", rgba.Rect.Dx(), rgba.Rect.Dy())
+		payload = rgba.Pix // non-premultiplied alpha, as kitty expects
+	}
+
+	if opts.ID > 0 {
+		control += fmt.Sprintf(",i=%d", opts.ID)
+	}
+	if opts.Cols > 0 {
+		control += fmt.Sprintf(",c=%d", opts.Cols)
+	}
+	if opts.Rows > 0 {
+		control += fmt.Sprintf(",r=%d", opts.Rows)
+	}
+	control += ",q=2" // suppress terminal responses
+
+	data := base64.StdEncoding.EncodeToString(payload)
+
+	var sb strings.Builder
+	for first := true; first || len(data) > 0; first = false {
+		chunk := data[:min(kittyChunkSize, len(data))]
+		data = data[len(chunk):]
+
+		more := 0
+		if len(data) > 0 {
+			more = 1
+		}
+		if first {
+			fmt.Fprintf(&sb, "\033_G%s,m=%d;%s\033\\", control, more, chunk)
+		} else {
+			fmt.Fprintf(&sb, "\033_Gm=%d;%s\033\\", more, chunk)
+		}
+	}
+	return sb.String(), nil
+}
+
+// DrawKitty writes the kitty graphics protocol form of an image to standard output (terminal).
+func DrawKitty(img image.Image, bg color.Color, opts KittyOptions) error {
+	render, err := RenderKitty(img, bg, opts)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Print(render)
+	return err
+}
+
+// RenderKittyDelete returns the kitty graphics protocol escape code that deletes
+// the image with the ID (and frees its data), or all visible images if ID is 0.
+func RenderKittyDelete(id uint32) string {
+	if id == 0 {
+		return "\033_Ga=d,d=A,q=2\033\\"
+	}
+	return fmt.Sprintf("\033_Ga=d,d=I,i=%d,q=2\033\\", id)
+}
@@ -0,0 +1,134 @@
+//       ___  _____  ____
+//      / _ \/  _/ |/_/ /____ ______ _
+//     / ___// /_>  </ __/ -_) __/  ' \
+//    /_/  /___/_/|_|\__/\__/_/ /_/_/_/
+//
+//    Copyright 2017 Eliuk Blau
+//
+//    This Source Code Form is subject to the terms of the Mozilla Public
+//    License, v. 2.0. If a copy of the MPL was not distributed with this
+//    file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package ansimage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"regexp"
+	"testing"
+)
+
+var reKittyChunk = regexp.MustCompile(`\x1b_G([^;]*);([^\x1b]*)\x1b\\`)
+
+// kittyChunks splits the kitty graphics protocol output in the control data
+// and payload of every escape code, failing if there is anything else.
+func kittyChunks(tb testing.TB, out string) (controls, chunks []string) {
+	tb.Helper()
+	for _, m := range reKittyChunk.FindAllStringSubmatch(out, -1) {
+		controls = append(controls, m[1])
+		chunks = append(chunks, m[2])
+	}
+	if rest := reKittyChunk.ReplaceAllString(out, ""); rest != "" {
+		tb.Fatalf("output has %q out of escape codes", rest)
+	}
+	return controls, chunks
+}
+
+func TestRenderKittyChunks(t *testing.T) {
+	tests := []struct {
+		name   string
+		w, h   int
+		chunks int
+	}{
+		{"one pixel", 1, 1, 1},
+		{"exact chunk", 32, 24, 1}, // 3072 bytes, 4096 in base64
+		{"chunk and a bit", 32, 25, 2},
+		{"many chunks", 40, 40, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := testImage(tt.h, tt.w)
+			out, err := RenderKitty(img, color.Black, KittyOptions{Format: KittyFormatRGBA, ID: 7, Cols: 4, Rows: 2})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			controls, chunks := kittyChunks(t, out)
+			if len(chunks) != tt.chunks {
+				t.Fatalf("%d chunks, want %d", len(chunks), tt.chunks)
+			}
+			var data string
+			for i, chunk := range chunks {
+				more := "m=1"
+				if i == len(chunks)-1 {
+					more = "m=0"
+				}
+				want := more
+				if i == 0 {
+					want = fmt.Sprintf("a=T,f=32,s=%d,v=%d,i=7,c=4,r=2,q=2,%s", tt.w, tt.h, more)
+				}
+				if controls[i] != want {
+					t.Errorf("chunk %d control = %q, want %q", i, controls[i], want)
+				}
+				if len(chunk) > kittyChunkSize || (i < len(chunks)-1 && len(chunk) != kittyChunkSize) {
+					t.Errorf("chunk %d has %d bytes, want %d", i, len(chunk), kittyChunkSize)
+				}
+				data += chunk
+			}
+
+			pix, err := base64.StdEncoding.DecodeString(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(pix, compositeImage(img, color.Black).Pix) {
+				t.Error("payload differs from image pixels")
+			}
+		})
+	}
+}
+
+func TestRenderKittyPNG(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	img.Set(1, 1, color.NRGBA{0x10, 0x20, 0x30, 0x80})
+
+	out, err := RenderKitty(img, color.Transparent, KittyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	controls, chunks := kittyChunks(t, out)
+	if len(controls) != 1 || controls[0] != "a=T,f=100,q=2,m=0" {
+		t.Fatalf("controls = %q, want one PNG escape code", controls)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(chunks[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := color.NRGBAModel.Convert(decoded.At(1, 1)); got != img.At(1, 1) {
+		t.Errorf("pixel = %v, want %v (alpha channel kept)", got, img.At(1, 1))
+	}
+}
+
+func TestRenderKittyUnknownFormat(t *testing.T) {
+	if _, err := RenderKitty(testImage(2, 2), color.Black, KittyOptions{Format: KittyFormatRGBA + 1}); !errors.Is(err, ErrUnknownKittyFormat) {
+		t.Errorf("RenderKitty error = %v, want %v", err, ErrUnknownKittyFormat)
+	}
+}
+
+func TestRenderKittyDelete(t *testing.T) {
+	if got, want := RenderKittyDelete(0), "\033_Ga=d,d=A,q=2\033\\"; got != want {
+		t.Errorf("RenderKittyDelete(0) = %q, want %q", got, want)
+	}
+	if got, want := RenderKittyDelete(42), "\033_Ga=d,d=I,i=42,q=2\033\\"; got != want {
+		t.Errorf("RenderKittyDelete(42) = %q, want %q", got, want)
+	}
+}
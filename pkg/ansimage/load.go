@@ -0,0 +1,243 @@
+//       ___  _____  ____
+//      / _ \/  _/ |/_/ /____ ______ _
+//     / ___// /_>  </ __/ -_) __/  ' \
+//    /_/  /___/_/|_|\__/\__/_/ /_/_/_/
+//
+//    Copyright 2017 Eliuk Blau
+//
+//    This Source Code Form is subject to the terms of the Mozilla Public
+//    License, v. 2.0. If a copy of the MPL was not distributed with this
+//    file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package ansimage
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"io"
+
+	"github.com/disintegration/imaging"
+)
+
+// Anchor type is used for anchor point constants to crop images in fill scale mode.
+type Anchor uint8
+
+// Filter type is used for resampling filter constants to scale images.
+type Filter uint8
+
+// ANSImage anchor points to crop images in fill scale mode
+const (
+	AnchorCenter = Anchor(iota)
+	AnchorTopLeft
+	AnchorTop
+	AnchorTopRight
+	AnchorLeft
+	AnchorRight
+	AnchorBottomLeft
+	AnchorBottom
+	AnchorBottomRight
+)
+
+// ANSImage resampling filters to scale images
+const (
+	FilterLanczos = Filter(iota)
+	FilterCatmullRom
+	FilterLinear
+	FilterBox
+	FilterNearestNeighbor
+)
+
+var anchors = map[Anchor]imaging.Anchor{
+	AnchorCenter:      imaging.Center,
+	AnchorTopLeft:     imaging.TopLeft,
+	AnchorTop:         imaging.Top,
+	AnchorTopRight:    imaging.TopRight,
+	AnchorLeft:        imaging.Left,
+	AnchorRight:       imaging.Right,
+	AnchorBottomLeft:  imaging.BottomLeft,
+	AnchorBottom:      imaging.Bottom,
+	AnchorBottomRight: imaging.BottomRight,
+}
+
+var filters = map[Filter]imaging.ResampleFilter{
+	FilterLanczos:         imaging.Lanczos,
+	FilterCatmullRom:      imaging.CatmullRom,
+	FilterLinear:          imaging.Linear,
+	FilterBox:             imaging.Box,
+	FilterNearestNeighbor: imaging.NearestNeighbor,
+}
+
+// Options are the options to load an ANSImage. Zero value loads the image without scaling,
+// nor dithering, in true color over black background.
+type Options struct {
+	Height, Width  int           // size in pixels to scale image (optional, 0 keeps image size; only one keeps aspect ratio)
+	ScaleMode      ScaleMode     // scale mode (optional, default: resize)
+	Anchor         Anchor        // anchor point to crop image in fill scale mode (optional, default: center)
+	Filter         Filter        // resampling filter to scale image (optional, default: Lanczos)
+	DitheringMode  DitheringMode // dithering mode, its Renderer is looked up in registry (optional, default: no dithering)
+	ColorDepth     ColorDepth    // output color depth (optional, default: true color)
+	Matte          color.Color   // background color to fill transparency or dithering mode (optional, default: black)
+	Palette        Palette       // terminal palette in 16 and 8 colors depths (optional, default: PaletteXterm)
+	ASCIIOnly      bool          // renders only 7-bit ASCII characters without color (optional)
+	Threshold      uint8         // brightness threshold to light braille dots (optional, default: adaptive)
+	Dither         Dither        // color dithering algorithm of pixels with reduced color depth or without color (optional, default: none)
+	DitherStrength *float64      // amount of quantization error diffused or threshold matrix added (optional, 0-1, default if nil: 1)
+	NoSerpentine   bool          // disables serpentine scanning of error diffusion (optional)
+	MaxProcs       int           // maximum number of parallel goroutines to convert and render image (optional, default: 1)
+	Fetcher        *Fetcher      // Fetcher to download images from URLs (optional, default: DefaultFetcher)
+}
+
+// validate returns an error if some option is unknown.
+func (opts Options) validate() error {
+	if opts.ScaleMode > ScaleModeFit {
+		return ErrUnknownScaleMode
+	}
+	if _, ok := anchors[opts.Anchor]; !ok {
+		return ErrUnknownAnchor
+	}
+	if _, ok := filters[opts.Filter]; !ok {
+		return ErrUnknownFilter
+	}
+	if opts.ColorDepth > ColorDepthNone {
+		return ErrUnknownColorDepth
+	}
+	if _, ok := LookupRenderer(opts.DitheringMode); !ok {
+		return ErrUnknownDitheringMode
+	}
+	if !opts.Dither.isValid() {
+		return ErrUnknownDither
+	}
+	return nil
+}
+
+// scaledOptions returns the Options of scaled ANSImage constructors.
+func scaledOptions(y, x int, bg color.Color, sm ScaleMode, dm DitheringMode) Options {
+	return Options{Height: y, Width: x, ScaleMode: sm, Matte: bg, DitheringMode: dm}
+}
+
+// Load creates a new ANSImage from a source string: an image file, standard input ("-"),
+// an image URL (HTTP/HTTPS), a data URI, a file URL, or an image inside an archive (see ParseSource).
+// The context cancels image download and stops loading between its steps.
+func Load(ctx context.Context, source string, opts Options) (*ANSImage, error) {
+	src, err := ParseSource(source, opts.Fetcher)
+	if err != nil {
+		return nil, err
+	}
+	return LoadSource(ctx, src, opts)
+}
+
+// LoadSource creates a new ANSImage from a Source of image data.
+// The context cancels opening source and stops loading between its steps.
+func LoadSource(ctx context.Context, src Source, opts Options) (*ANSImage, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err // before opening source (e.g. downloading image)
+	}
+	reader, err := src.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return LoadReader(ctx, reader, opts)
+}
+
+// LoadReader creates a new ANSImage from an io.Reader with image data.
+// The context stops loading between its steps.
+func LoadReader(ctx context.Context, reader io.Reader, opts Options) (*ANSImage, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err // before decoding image
+	}
+	img, _, err := image.Decode(reader)
+	if err != nil {
+		return nil, err
+	}
+	return loadImage(ctx, img, opts)
+}
+
+// loadImage creates a new ANSImage from an image.Image, scaled to the size in options.
+func loadImage(ctx context.Context, img image.Image, opts Options) (*ANSImage, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	img, err := scaleImage(img, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	renderer, _ := LookupRenderer(opts.DitheringMode)
+	py, px := renderer.PixelSize()
+	cy, cx := renderer.CellSize()
+
+	bounds := img.Bounds()
+	yMax := bounds.Dy() / py // always sets 1 ANSIPixel...
+	xMax := bounds.Dx() / px // per block of real pixels (e.g. 8x4 --> with dithering)
+	yMax = yMax - yMax%cy    // always sets ANSIPixel rows and columns multiple of cell size
+	xMax = xMax - xMax%cx    // (e.g. upper and lower pixel --> without dithering)
+
+	bg := opts.Matte
+	if bg == nil {
+		bg = color.Black
+	}
+
+	ansimage, err := New(yMax, xMax, bg, opts.DitheringMode)
+	if err != nil {
+		return nil, err
+	}
+	if err := ansimage.SetColorDepth(opts.ColorDepth); err != nil {
+		return nil, err
+	}
+	ansimage.SetMaxProcs(max(opts.MaxProcs, 1))
+	if opts.Palette != (Palette{}) {
+		ansimage.SetPalette(opts.Palette)
+	}
+	ansimage.SetASCIIOnly(opts.ASCIIOnly)
+	ansimage.SetThreshold(opts.Threshold)
+	ansimage.SetDither(opts.Dither) // settings of dithering are used by SetImage
+	if opts.DitherStrength != nil {
+		ansimage.SetDitherStrength(*opts.DitherStrength)
+	}
+	ansimage.SetSerpentine(!opts.NoSerpentine)
+
+	if err := ansimage.SetImage(img); err != nil {
+		return nil, err
+	}
+
+	return ansimage, nil
+}
+
+// scaleImage returns a scaled copy of an image.Image to the size in options (or the same image
+// without size), using the scale mode, anchor point and resampling filter.
+func scaleImage(img image.Image, opts Options) (image.Image, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	y, x := opts.Height, opts.Width
+	if y == 0 && x == 0 {
+		return img, nil
+	}
+
+	filter := filters[opts.Filter]
+	if opts.ScaleMode == ScaleModeResize {
+		return imaging.Resize(img, x, y, filter), nil // keeps aspect ratio if a size is 0
+	}
+
+	bounds := img.Bounds()
+	if y == 0 {
+		y = bounds.Dy()
+	}
+	if x == 0 {
+		x = bounds.Dx()
+	}
+	if opts.ScaleMode == ScaleModeFill {
+		return imaging.Fill(img, x, y, anchors[opts.Anchor], filter), nil
+	}
+	return imaging.Fit(img, x, y, filter), nil
+}
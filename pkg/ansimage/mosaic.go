@@ -0,0 +1,213 @@
+//       ___  _____  ____
+//      / _ \/  _/ |/_/ /____ ______ _
+//     / ___// /_>  </ __/ -_) __/  ' \
+//    /_/  /___/_/|_|\__/\__/_/ /_/_/_/
+//
+//    Copyright 2017 Eliuk Blau
+//
+//    This Source Code Form is subject to the terms of the Mozilla Public
+//    License, v. 2.0. If a copy of the MPL was not distributed with this
+//    file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package ansimage
+
+// Mosaic glyphs split each terminal cell in 2 columns of 2 (quadrants), 3 (sextants) or
+// 4 (octants) rows. The bit (2*y + x) of a mosaic mask is set for the sub-cell (y,x) that
+// is painted with the foreground color; the other sub-cells show the background color.
+// INFO: https://en.wikipedia.org/wiki/Symbols_for_Legacy_Computing
+
+// quadrantGlyphs are the block elements indexed by quadrant mosaic mask.
+var quadrantGlyphs = []rune(" ▘▝▀▖▌▞▛▗▚▐▜▄▙▟█")
+
+// quadrantGlyph returns the glyph of a quadrant mosaic mask.
+func quadrantGlyph(mask int) rune {
+	return quadrantGlyphs[mask]
+}
+
+// sextantGlyph returns the glyph of a sextant mosaic mask. Block sextants are sorted by mask,
+// except the ones that already exist as block elements (empty, left half, right half and full).
+func sextantGlyph(mask int) rune {
+	switch mask {
+	case 0:
+		return ' '
+	case 21:
+		return '▌'
+	case 42:
+		return '▐'
+	case 63:
+		return '█'
+	}
+	r := rune(0x1fb00 + mask - 1) // BLOCK SEXTANT-1
+	if mask > 21 {
+		r--
+	}
+	if mask > 42 {
+		r--
+	}
+	return r
+}
+
+// octantGlyphs are the block octants (Unicode 16) indexed by octant mosaic mask.
+// Masks that already exist as block elements or legacy computing symbols use them instead.
+var octantGlyphs = [256]rune{
+	' ', 0x1cea8, 0x1ceab, 0x1fb82, 0x1cd00, 0x2598, 0x1cd01, 0x1cd02,
+	0x1cd03, 0x1cd04, 0x259d, 0x1cd05, 0x1cd06, 0x1cd07, 0x1cd08, 0x2580,
+	0x1cd09, 0x1cd0a, 0x1cd0b, 0x1cd0c, 0x1fbe6, 0x1cd0d, 0x1cd0e, 0x1cd0f,
+	0x1cd10, 0x1cd11, 0x1cd12, 0x1cd13, 0x1cd14, 0x1cd15, 0x1cd16, 0x1cd17,
+	0x1cd18, 0x1cd19, 0x1cd1a, 0x1cd1b, 0x1cd1c, 0x1cd1d, 0x1cd1e, 0x1cd1f,
+	0x1fbe7, 0x1cd20, 0x1cd21, 0x1cd22, 0x1cd23, 0x1cd24, 0x1cd25, 0x1cd26,
+	0x1cd27, 0x1cd28, 0x1cd29, 0x1cd2a, 0x1cd2b, 0x1cd2c, 0x1cd2d, 0x1cd2e,
+	0x1cd2f, 0x1cd30, 0x1cd31, 0x1cd32, 0x1cd33, 0x1cd34, 0x1cd35, 0x1fb85,
+	0x1cea3, 0x1cd36, 0x1cd37, 0x1cd38, 0x1cd39, 0x1cd3a, 0x1cd3b, 0x1cd3c,
+	0x1cd3d, 0x1cd3e, 0x1cd3f, 0x1cd40, 0x1cd41, 0x1cd42, 0x1cd43, 0x1cd44,
+	0x2596, 0x1cd45, 0x1cd46, 0x1cd47, 0x1cd48, 0x258c, 0x1cd49, 0x1cd4a,
+	0x1cd4b, 0x1cd4c, 0x259e, 0x1cd4d, 0x1cd4e, 0x1cd4f, 0x1cd50, 0x259b,
+	0x1cd51, 0x1cd52, 0x1cd53, 0x1cd54, 0x1cd55, 0x1cd56, 0x1cd57, 0x1cd58,
+	0x1cd59, 0x1cd5a, 0x1cd5b, 0x1cd5c, 0x1cd5d, 0x1cd5e, 0x1cd5f, 0x1cd60,
+	0x1cd61, 0x1cd62, 0x1cd63, 0x1cd64, 0x1cd65, 0x1cd66, 0x1cd67, 0x1cd68,
+	0x1cd69, 0x1cd6a, 0x1cd6b, 0x1cd6c, 0x1cd6d, 0x1cd6e, 0x1cd6f, 0x1cd70,
+	0x1cea0, 0x1cd71, 0x1cd72, 0x1cd73, 0x1cd74, 0x1cd75, 0x1cd76, 0x1cd77,
+	0x1cd78, 0x1cd79, 0x1cd7a, 0x1cd7b, 0x1cd7c, 0x1cd7d, 0x1cd7e, 0x1cd7f,
+	0x1cd80, 0x1cd81, 0x1cd82, 0x1cd83, 0x1cd84, 0x1cd85, 0x1cd86, 0x1cd87,
+	0x1cd88, 0x1cd89, 0x1cd8a, 0x1cd8b, 0x1cd8c, 0x1cd8d, 0x1cd8e, 0x1cd8f,
+	0x2597, 0x1cd90, 0x1cd91, 0x1cd92, 0x1cd93, 0x259a, 0x1cd94, 0x1cd95,
+	0x1cd96, 0x1cd97, 0x2590, 0x1cd98, 0x1cd99, 0x1cd9a, 0x1cd9b, 0x259c,
+	0x1cd9c, 0x1cd9d, 0x1cd9e, 0x1cd9f, 0x1cda0, 0x1cda1, 0x1cda2, 0x1cda3,
+	0x1cda4, 0x1cda5, 0x1cda6, 0x1cda7, 0x1cda8, 0x1cda9, 0x1cdaa, 0x1cdab,
+	0x2582, 0x1cdac, 0x1cdad, 0x1cdae, 0x1cdaf, 0x1cdb0, 0x1cdb1, 0x1cdb2,
+	0x1cdb3, 0x1cdb4, 0x1cdb5, 0x1cdb6, 0x1cdb7, 0x1cdb8, 0x1cdb9, 0x1cdba,
+	0x1cdbb, 0x1cdbc, 0x1cdbd, 0x1cdbe, 0x1cdbf, 0x1cdc0, 0x1cdc1, 0x1cdc2,
+	0x1cdc3, 0x1cdc4, 0x1cdc5, 0x1cdc6, 0x1cdc7, 0x1cdc8, 0x1cdc9, 0x1cdca,
+	0x1cdcb, 0x1cdcc, 0x1cdcd, 0x1cdce, 0x1cdcf, 0x1cdd0, 0x1cdd1, 0x1cdd2,
+	0x1cdd3, 0x1cdd4, 0x1cdd5, 0x1cdd6, 0x1cdd7, 0x1cdd8, 0x1cdd9, 0x1cdda,
+	0x2584, 0x1cddb, 0x1cddc, 0x1cddd, 0x1cdde, 0x2599, 0x1cddf, 0x1cde0,
+	0x1cde1, 0x1cde2, 0x259f, 0x1cde3, 0x2586, 0x1cde4, 0x1cde5, 0x2588,
+}
+
+// octantGlyph returns the glyph of an octant mosaic mask.
+func octantGlyph(mask int) rune {
+	return octantGlyphs[mask]
+}
+
+// mosaicMasks are all the masks of mosaic cells (8 sub-cells at most), in order.
+var mosaicMasks = func() (masks [1 << (OctantSizeY * MosaicSizeX)]uint32) {
+	for i := range masks {
+		masks[i] = uint32(i)
+	}
+	return
+}()
+
+// encodeMosaicCell returns the ANSI-compatible string form of the mosaic cell with size
+// (sy, MosaicSizeX) and its glyphs. The pixels of the cell are split in two color clusters,
+// choosing the glyph with the least squared error; the cluster of set sub-cells is the
+// foreground and the other one is the background. If background color is disabled,
+// the other cluster is the background color.
+func (ai *ANSImage) encodeMosaicCell(rc *RenderContext, cell Cell, sy int, glyph func(mask int) rune) string {
+	sx := MosaicSizeX
+
+	var (
+		pixels [OctantSizeY * MosaicSizeX]ANSIpixel
+		n      = sy * sx
+	)
+	for dy := 0; dy < sy; dy++ {
+		for dx := 0; dx < sx; dx++ {
+			pixels[dy*sx+dx] = cell.At(dy, dx)
+		}
+	}
+
+	// WITHOUT COLOR
+	if ai.colordepth == ColorDepthNone {
+		threshold := ai.cellThreshold(cell, sy, sx)
+		mask, sumBri := 0, 0
+		for i, p := range pixels[:n] {
+			sumBri += int(p.Brightness)
+			isLit := p.Brightness > threshold
+			if ai.inverted {
+				isLit = !isLit
+			}
+			if isLit {
+				mask |= 1 << i
+			}
+		}
+		if ai.asciiOnly {
+			// brightness ramp is inverted by glyphForBrightness
+			return ai.glyphForBrightness(uint8(sumBri / n))
+		}
+		return string(glyph(mask))
+	}
+
+	masks := mosaicMasks[:1<<n]
+	if !rc.DisableBgColor {
+		masks = masks[:1<<(n-1)] // a mask and its complement are the same split, last pixel is always unset
+	}
+	best, fg, bg := ai.bestSplit(pixels[:n], masks, rc.DisableBgColor)
+
+	bgColor := CellRGB(bg[0], bg[1], bg[2])
+	if rc.DisableBgColor {
+		bgColor = CellColor{}
+	}
+	if masks[best] == 0 {
+		return rc.Paint(bgColor, CellColor{}, " ")
+	}
+	return rc.Paint(bgColor, CellRGB(fg[0], fg[1], fg[2]), string(glyph(int(masks[best]))))
+}
+
+// bestSplit returns the index of the mask (bit i is set for pixel i) that splits the pixels in
+// two color clusters with the least squared error, and the mean colors of the set (fg) and
+// unset (bg) pixels. If fixedBg is true, the unset pixels always take the background color
+// of ANSImage. It is used by mosaic and glyph dithering modes.
+func (ai *ANSImage) bestSplit(pixels []ANSIpixel, masks []uint32, fixedBg bool) (best int, fg, bg [3]uint8) {
+	var (
+		total    [3]int
+		bgColor  = [3]int{int(ai.bgR), int(ai.bgG), int(ai.bgB)}
+		bestErr  float64
+		bestSums [2][3]int
+		bestSize int
+	)
+	for _, p := range pixels {
+		total[0] += int(p.R)
+		total[1] += int(p.G)
+		total[2] += int(p.B)
+	}
+
+	for m, mask := range masks {
+		var set [3]int
+		size := 0
+		for i, p := range pixels {
+			if mask&(1<<i) != 0 {
+				set[0] += int(p.R)
+				set[1] += int(p.G)
+				set[2] += int(p.B)
+				size++
+			}
+		}
+		unset := [3]int{total[0] - set[0], total[1] - set[1], total[2] - set[2]}
+
+		// squared error minus the (constant) sum of squared pixel values
+		var err float64
+		for c := 0; c < 3; c++ {
+			if size > 0 {
+				err -= float64(set[c]*set[c]) / float64(size)
+			}
+			if fixedBg {
+				err += float64((len(pixels)-size)*bgColor[c]*bgColor[c] - 2*bgColor[c]*unset[c])
+			} else if size < len(pixels) {
+				err -= float64(unset[c]*unset[c]) / float64(len(pixels)-size)
+			}
+		}
+		if m == 0 || err < bestErr {
+			best, bestErr, bestSums, bestSize = m, err, [2][3]int{set, unset}, size
+		}
+	}
+
+	bg = [3]uint8{ai.bgR, ai.bgG, ai.bgB}
+	for c := 0; c < 3; c++ {
+		if bestSize > 0 {
+			fg[c] = uint8(bestSums[0][c] / bestSize)
+		}
+		if !fixedBg && bestSize < len(pixels) {
+			bg[c] = uint8(bestSums[1][c] / (len(pixels) - bestSize))
+		}
+	}
+	return best, fg, bg
+}
@@ -0,0 +1,52 @@
+//       ___  _____  ____
+//      / _ \/  _/ |/_/ /____ ______ _
+//     / ___// /_>  </ __/ -_) __/  ' \
+//    /_/  /___/_/|_|\__/\__/_/ /_/_/_/
+//
+//    Copyright 2017 Eliuk Blau
+//
+//    This Source Code Form is subject to the terms of the Mozilla Public
+//    License, v. 2.0. If a copy of the MPL was not distributed with this
+//    file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package ansimage
+
+import (
+	"testing"
+)
+
+func TestMosaicSplit(t *testing.T) {
+	const (
+		red  = "255;0;0"
+		blue = "0;0;255"
+	)
+	tests := []struct {
+		name   string
+		dm     DitheringMode
+		rows   []string
+		rc     RenderContext
+		want   string
+		fg, bg string
+	}{
+		{"quadrant first pixel", DitheringWithQuadrants, []string{"RB", "BB"}, RenderContext{}, "▘", red, blue},
+		{"quadrant last pixel", DitheringWithQuadrants, []string{"BB", "BR"}, RenderContext{}, "▛", blue, red}, // last pixel is background
+		{"quadrant diagonal", DitheringWithQuadrants, []string{"RB", "BR"}, RenderContext{}, "▞", blue, red},
+		{"sextant left", DitheringWithSextants, []string{"RB", "RB", "RB"}, RenderContext{}, "▌", red, blue},
+		{"sextant middle", DitheringWithSextants, []string{"BB", "RR", "BB"}, RenderContext{}, "\U0001fb0b", red, blue}, // mask 12
+		{"octant top", DitheringWithOctants, []string{"RR", "BB", "BB", "BB"}, RenderContext{}, "\U0001fb82", red, blue},
+		{"octant last pixel", DitheringWithOctants, []string{"BB", "BB", "BB", "BR"}, RenderContext{}, "\U0001cd70", blue, red}, // mask 127
+		{"fixed background", DitheringWithQuadrants, []string{"00", "0R"}, RenderContext{DisableBgColor: true}, "▗", red, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := "\033[38;2;" + tt.fg + "m" + tt.want
+			if tt.bg != "" {
+				want = "\033[48;2;" + tt.bg + "m" + want
+			}
+			got := encodeFirstCell(t, cellImage(tt.rows...), Options{DitheringMode: tt.dm}, tt.rc)
+			if got != want {
+				t.Errorf("mosaic cell = %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+//       ___  _____  ____
+//      / _ \/  _/ |/_/ /____ ______ _
+//     / ___// /_>  </ __/ -_) __/  ' \
+//    /_/  /___/_/|_|\__/\__/_/ /_/_/_/
+//
+//    Copyright 2017 Eliuk Blau
+//
+//    This Source Code Form is subject to the terms of the Mozilla Public
+//    License, v. 2.0. If a copy of the MPL was not distributed with this
+//    file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package ansimage
+
+import (
+	"fmt"
+	"image/color"
+	"strings"
+	"sync"
+
+	"github.com/lucasb-eyer/go-colorful"
+)
+
+// Palette represents the 16 basic colors of a terminal theme
+// (SGR colors 30-37 & 40-47, and bright colors 90-97 & 100-107).
+// It is used to find the nearest color in 16 and 8 colors depths.
+type Palette [16]color.RGBA
+
+// Terminal palettes commonly used by terminal emulators.
+// (info - https://en.wikipedia.org/wiki/ANSI_escape_code#3-bit_and_4-bit)
+var (
+	// PaletteXterm is the default palette of xterm.
+	PaletteXterm = mustParsePalette(
+		"000000", "cd0000", "00cd00", "cdcd00", "0000ee", "cd00cd", "00cdcd", "e5e5e5",
+		"7f7f7f", "ff0000", "00ff00", "ffff00", "5c5cff", "ff00ff", "00ffff", "ffffff",
+	)
+
+	// PaletteVGA is the palette of VGA text mode (also used by the Linux console).
+	PaletteVGA = mustParsePalette(
+		"000000", "aa0000", "00aa00", "aa5500", "0000aa", "aa00aa", "00aaaa", "aaaaaa",
+		"555555", "ff5555", "55ff55", "ffff55", "5555ff", "ff55ff", "55ffff", "ffffff",
+	)
+
+	// PaletteSolarized is the palette of Solarized theme.
+	PaletteSolarized = mustParsePalette(
+		"073642", "dc322f", "859900", "b58900", "268bd2", "d33682", "2aa198", "eee8d5",
+		"002b36", "cb4b16", "586e75", "657b83", "839496", "6c71c4", "93a1a1", "fdf6e3",
+	)
+
+	// PaletteTango is the palette of Tango theme (used by GNOME Terminal).
+	PaletteTango = mustParsePalette(
+		"000000", "cc0000", "4e9a06", "c4a000", "3465a4", "75507b", "06989a", "d3d7cf",
+		"555753", "ef2929", "8ae234", "fce94f", "729fcf", "ad7fa8", "34e2e2", "eeeeec",
+	)
+)
+
+// ParsePalette creates a Palette from a list of 16 colors in hex format
+// (e.g. "ff0000" or "#ff0000"), ordered from SGR color 0 to 15.
+func ParsePalette(colors ...string) (Palette, error) {
+	var p Palette
+	if len(colors) != len(p) {
+		return p, ErrInvalidPalette
+	}
+	for i, hex := range colors {
+		c, err := colorful.Hex("#" + strings.TrimPrefix(strings.TrimSpace(hex), "#"))
+		if err != nil {
+			return p, fmt.Errorf("%w: %s is not a hex-color", ErrInvalidPalette, hex)
+		}
+		r, g, b := c.RGB255()
+		p[i] = color.RGBA{r, g, b, 0xff}
+	}
+	return p, nil
+}
+
+// mustParsePalette is like ParsePalette but panics if the colors are invalid.
+func mustParsePalette(colors ...string) Palette {
+	p, err := ParsePalette(colors...)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// xterm256 matches colors against the xterm 256-color palette (only the
+// 6x6x6 color cube and the grayscale ramp, indexes 16-255). The first 16
+// colors are left out because each terminal theme defines them differently.
+// (info - https://en.wikipedia.org/wiki/ANSI_escape_code#8-bit)
+var xterm256 = newColorMatcher(xterm256Colors(), 16)
+
+// colorMatcher finds the perceptually nearest color of a palette.
+// Distance is computed in CIE L*a*b* color space and results are cached.
+type colorMatcher struct {
+	offset int
+	colors []color.RGBA
+	lab    [][3]float64
+	cache  sync.Map // uint32 (RGB) -> int (palette index)
+}
+
+// newColorMatcher creates a colorMatcher for the given colors.
+// Offset is added to the palette index returned by the matcher.
+func newColorMatcher(colors []color.RGBA, offset int) *colorMatcher {
+	cm := &colorMatcher{
+		offset: offset,
+		colors: colors,
+		lab:    make([][3]float64, len(colors)),
+	}
+	for i, c := range colors {
+		l, a, b := rgbToColorful(c.R, c.G, c.B).Lab()
+		cm.lab[i] = [3]float64{l, a, b}
+	}
+	return cm
+}
+
+// index returns the palette index of the nearest color to (r,g,b).
+func (cm *colorMatcher) index(r, g, b uint8) int {
+	key := uint32(r)<<16 | uint32(g)<<8 | uint32(b)
+	if v, ok := cm.cache.Load(key); ok {
+		return v.(int)
+	}
+
+	l, a, bb := rgbToColorful(r, g, b).Lab()
+	best, bestDist := 0, -1.0
+	for i, v := range cm.lab {
+		dl, da, db := l-v[0], a-v[1], bb-v[2]
+		if dist := dl*dl + da*da + db*db; bestDist < 0 || dist < bestDist {
+			best, bestDist = i, dist
+		}
+	}
+
+	best += cm.offset
+	cm.cache.Store(key, best)
+	return best
+}
+
+// nearest returns the nearest color of the palette to (r,g,b).
+func (cm *colorMatcher) nearest(r, g, b uint8) color.RGBA {
+	return cm.colors[cm.index(r, g, b)-cm.offset]
+}
+
+// xterm256Colors returns the RGB values of xterm colors 16-255.
+func xterm256Colors() []color.RGBA {
+	levels := [6]uint8{0, 95, 135, 175, 215, 255}
+
+	colors := make([]color.RGBA, 0, 240)
+	for r := 0; r < 6; r++ {
+		for g := 0; g < 6; g++ {
+			for b := 0; b < 6; b++ {
+				colors = append(colors, color.RGBA{levels[r], levels[g], levels[b], 0xff})
+			}
+		}
+	}
+	for i := 0; i < 24; i++ {
+		v := uint8(8 + 10*i)
+		colors = append(colors, color.RGBA{v, v, v, 0xff})
+	}
+	return colors
+}
+
+// rgbToColorful converts 8bit color components to colorful.Color.
+func rgbToColorful(r, g, b uint8) colorful.Color {
+	return colorful.Color{
+		R: float64(r) / 255.0,
+		G: float64(g) / 255.0,
+		B: float64(b) / 255.0,
+	}
+}
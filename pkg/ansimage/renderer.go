@@ -0,0 +1,354 @@
+//       ___  _____  ____
+//      / _ \/  _/ |/_/ /____ ______ _
+//     / ___// /_>  </ __/ -_) __/  ' \
+//    /_/  /___/_/|_|\__/\__/_/ /_/_/_/
+//
+//    Copyright 2017 Eliuk Blau
+//
+//    This Source Code Form is subject to the terms of the Mozilla Public
+//    License, v. 2.0. If a copy of the MPL was not distributed with this
+//    file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package ansimage
+
+import (
+	"cmp"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// CellEncoder encodes the ANSI-pixels of each terminal cell as ANSI-compatible text.
+type CellEncoder interface {
+	// CellSize returns the size in ANSI-pixels (y,x) of each terminal cell.
+	CellSize() (y, x int)
+
+	// EncodeCell returns the ANSI-compatible string form of a terminal cell.
+	EncodeCell(rc *RenderContext, cell Cell) string
+}
+
+// Renderer renders an ANSImage in a dithering mode: the image is sampled in ANSI-pixels,
+// that are encoded by terminal cells, and each row of cells is ended by a terminator.
+// Renderers of dithering modes are looked up in a registry (see RegisterRenderer).
+type Renderer interface {
+	CellEncoder
+
+	// PixelSize returns the size in image pixels (y,x) averaged in each ANSI-pixel.
+	PixelSize() (y, x int)
+
+	// RowEnd returns the string that ends each row of terminal cells.
+	RowEnd(rc *RenderContext) string
+}
+
+// RenderContext is the ANSImage being rendered and the options of render,
+// with helpers to encode terminal cells according to them.
+// Each row of terminal cells is encoded with its own RenderContext.
+type RenderContext struct {
+	Image          *ANSImage
+	GoCode         bool  // renders in form of Go code 'fmt.Printf()'
+	DisableBgColor bool  // disables background color in dithering mode
+	Optimize       bool  // minimizes escape sequences (see Paint)
+	Tolerance      uint8 // max difference per color component to reuse current colors when optimizing
+
+	bg, fg             CellColor // current colors
+	bgParams, fgParams string    // SGR parameters of current colors
+}
+
+// CellColor is a color of a terminal cell. Unset colors are the default colors of terminal.
+type CellColor struct {
+	R, G, B uint8
+	Set     bool
+}
+
+// CellRGB returns the CellColor (r,g,b).
+func CellRGB(r, g, b uint8) CellColor {
+	return CellColor{R: r, G: g, B: b, Set: true}
+}
+
+// near reports if both colors are set and their difference is within tolerance.
+func (c CellColor) near(o CellColor, tolerance uint8) bool {
+	diff := func(a, b uint8) uint8 {
+		if a > b {
+			return a - b
+		}
+		return b - a
+	}
+	return c.Set && o.Set && diff(c.R, o.R) <= tolerance && diff(c.G, o.G) <= tolerance && diff(c.B, o.B) <= tolerance
+}
+
+// Escape returns the escape char, escaped itself if rendering Go code.
+func (rc *RenderContext) Escape() string {
+	if rc.GoCode {
+		return "\\033"
+	}
+	return "\033"
+}
+
+// Newline returns the new line char, escaped if rendering Go code.
+func (rc *RenderContext) Newline() string {
+	if rc.GoCode {
+		return "\\n"
+	}
+	return "\n"
+}
+
+// SGR returns the escape sequence that sets the foreground or background color (r,g,b),
+// according to the color depth of ANSImage (empty without color). It is never optimized.
+func (rc *RenderContext) SGR(background bool, r, g, b uint8) string {
+	if rc.Image.colordepth == ColorDepthNone {
+		return ""
+	}
+	return fmt.Sprintf("%s[%sm", rc.Escape(), rc.Image.sgrColor(background, r, g, b))
+}
+
+// Background returns the escape sequence that sets the background color of ANSImage
+// (empty without color or if background color is disabled).
+func (rc *RenderContext) Background() string {
+	if rc.DisableBgColor {
+		return ""
+	}
+	return rc.SGR(true, rc.Image.bgR, rc.Image.bgG, rc.Image.bgB)
+}
+
+// BackgroundColor returns the background color of ANSImage (unset if background color is disabled).
+func (rc *RenderContext) BackgroundColor() CellColor {
+	if rc.DisableBgColor {
+		return CellColor{}
+	}
+	return CellRGB(rc.Image.bgR, rc.Image.bgG, rc.Image.bgB)
+}
+
+// Reset returns the escape sequence that resets ANSI style (empty without color),
+// and forgets the current colors.
+func (rc *RenderContext) Reset() string {
+	rc.bg, rc.fg = CellColor{}, CellColor{}
+	rc.bgParams, rc.fgParams = "", ""
+	if rc.Image.colordepth == ColorDepthNone {
+		return ""
+	}
+	return rc.Escape() + "[0m"
+}
+
+// Paint returns a glyph with the escape sequences that set its background and foreground colors
+// (only the glyph without color). When optimizing, colors already set by previous cells are
+// skipped, colors within tolerance of them are reused, both colors are merged in one sequence,
+// and glyphs with the same colors are replaced by a space (or a full block if it is shorter).
+func (rc *RenderContext) Paint(bg, fg CellColor, glyph string) string {
+	if rc.Image.colordepth == ColorDepthNone {
+		return glyph
+	}
+
+	if !rc.Optimize {
+		var str string
+		if bg.Set {
+			str += rc.SGR(true, bg.R, bg.G, bg.B)
+		}
+		if fg.Set {
+			str += rc.SGR(false, fg.R, fg.G, fg.B)
+		}
+		return str + glyph
+	}
+
+	// near-identical colors are quantized to current ones
+	if bg.near(rc.bg, rc.Tolerance) {
+		bg = rc.bg
+	}
+	if fg.near(rc.fg, rc.Tolerance) {
+		fg = rc.fg
+	}
+	bgParams, fgParams := rc.sgrParams(true, bg), rc.sgrParams(false, fg)
+
+	// glyph with the same colors is a space, or a full block if only background color changes
+	if bg.Set && fg.Set && (fg.near(bg, rc.Tolerance) || rc.sgrParams(true, fg) == bgParams) {
+		if r, _ := utf8.DecodeRuneInString(glyph); r >= utf8.RuneSelf && fgParams == rc.fgParams && bgParams != rc.bgParams {
+			glyph = fullBlock // ASCII glyphs are kept ASCII-only
+		} else {
+			glyph = " "
+		}
+	}
+
+	var params []string
+	if glyph != fullBlock && bgParams != rc.bgParams { // background is hidden by full block
+		params = append(params, cmp.Or(bgParams, "49")) // unset is default background color
+		rc.bg, rc.bgParams = bg, bgParams
+	}
+	if glyph != " " && fgParams != rc.fgParams { // foreground is not shown by space
+		params = append(params, cmp.Or(fgParams, "39")) // unset is default foreground color
+		rc.fg, rc.fgParams = fg, fgParams
+	}
+	if len(params) == 0 {
+		return glyph
+	}
+	return fmt.Sprintf("%s[%sm%s", rc.Escape(), strings.Join(params, ";"), glyph)
+}
+
+// sgrParams returns the SGR parameters that set a color (empty if unset).
+func (rc *RenderContext) sgrParams(background bool, c CellColor) string {
+	if !c.Set {
+		return ""
+	}
+	return rc.Image.sgrColor(background, c.R, c.G, c.B)
+}
+
+// Cell is a terminal cell of the ANSImage being rendered.
+type Cell struct {
+	Row, Col int // cell coordinates
+	image    *ANSImage
+	y, x     int // coordinates of upper-left ANSI-pixel
+}
+
+// At returns the ANSI-pixel in coordinates (y,x) of the cell.
+func (c Cell) At(y, x int) ANSIpixel {
+	return c.image.ansiPixel(c.y+y, c.x+x)
+}
+
+var (
+	renderersMu sync.RWMutex
+	renderers   = map[DitheringMode]Renderer{
+		NoDithering:            halfBlockRenderer{},
+		DitheringWithBlocks:    rampRenderer{},
+		DitheringWithChars:     rampRenderer{},
+		DitheringWithBraille:   brailleRenderer{},
+		DitheringWithQuadrants: mosaicRenderer{sizeY: QuadrantSizeY, glyph: quadrantGlyph},
+		DitheringWithSextants:  mosaicRenderer{sizeY: SextantSizeY, glyph: sextantGlyph},
+		DitheringWithOctants:   mosaicRenderer{sizeY: OctantSizeY, glyph: octantGlyph},
+		DitheringWithGlyphs:    glyphRenderer{},
+	}
+)
+
+// RegisterRenderer registers the Renderer of a dithering mode, so ANSImage can be created
+// and rendered with it. Use a dithering mode not defined by this package to add a new one,
+// or replace the Renderer of an existing one.
+func RegisterRenderer(dm DitheringMode, r Renderer) {
+	renderersMu.Lock()
+	defer renderersMu.Unlock()
+	renderers[dm] = r
+}
+
+// LookupRenderer returns the Renderer registered for a dithering mode, if any.
+func LookupRenderer(dm DitheringMode) (Renderer, bool) {
+	renderersMu.RLock()
+	defer renderersMu.RUnlock()
+	r, ok := renderers[dm]
+	return r, ok
+}
+
+// resetRowEnd ends each row of terminal cells resetting ANSI style.
+type resetRowEnd struct{}
+
+// RowEnd returns the string that ends each row of terminal cells.
+func (resetRowEnd) RowEnd(rc *RenderContext) string {
+	return rc.Reset() + rc.Newline()
+}
+
+// rawPixels samples one ANSI-pixel per image pixel.
+type rawPixels struct{}
+
+// PixelSize returns the size in image pixels (y,x) averaged in each ANSI-pixel.
+func (rawPixels) PixelSize() (y, x int) {
+	return 1, 1
+}
+
+// halfBlockRenderer renders 2 pixels per terminal cell, with background color
+// for upper pixel and lower half block with foreground color for lower pixel.
+type halfBlockRenderer struct {
+	rawPixels
+	resetRowEnd
+}
+
+// CellSize returns the size in ANSI-pixels (y,x) of each terminal cell.
+func (halfBlockRenderer) CellSize() (y, x int) {
+	return 2, 1 // upper and lower pixels
+}
+
+// EncodeCell returns the ANSI-compatible string form of a terminal cell.
+func (halfBlockRenderer) EncodeCell(rc *RenderContext, cell Cell) string {
+	upper, lower := cell.At(0, 0), cell.At(1, 0)
+
+	// WITHOUT COLOR
+	if rc.Image.colordepth == ColorDepthNone {
+		return rc.Image.halfBlocksForBrightness(upper.Brightness, lower.Brightness)
+	}
+
+	return rc.Paint(CellRGB(upper.R, upper.G, upper.B), CellRGB(lower.R, lower.G, lower.B), lowerHalfBlock)
+}
+
+// rampRenderer renders a block of BlockSizeY x BlockSizeX pixels per terminal cell, with
+// a glyph of a brightness ramp (blocks or chars) colored with the average color.
+type rampRenderer struct {
+	resetRowEnd
+}
+
+// PixelSize returns the size in image pixels (y,x) averaged in each ANSI-pixel.
+func (rampRenderer) PixelSize() (y, x int) {
+	return BlockSizeY, BlockSizeX
+}
+
+// CellSize returns the size in ANSI-pixels (y,x) of each terminal cell.
+func (rampRenderer) CellSize() (y, x int) {
+	return 1, 1
+}
+
+// EncodeCell returns the ANSI-compatible string form of a terminal cell.
+func (rampRenderer) EncodeCell(rc *RenderContext, cell Cell) string {
+	p := cell.At(0, 0)
+	block := rc.Image.glyphForBrightness(p.Brightness)
+
+	// WITHOUT COLOR
+	if rc.Image.colordepth == ColorDepthNone {
+		return block
+	}
+
+	return rc.Paint(rc.BackgroundColor(), CellRGB(p.R, p.G, p.B), block)
+}
+
+// brailleRenderer renders BrailleSizeY x BrailleSizeX pixels per terminal cell as braille dots.
+type brailleRenderer struct {
+	rawPixels
+	resetRowEnd
+}
+
+// CellSize returns the size in ANSI-pixels (y,x) of each terminal cell.
+func (brailleRenderer) CellSize() (y, x int) {
+	return BrailleSizeY, BrailleSizeX
+}
+
+// EncodeCell returns the ANSI-compatible string form of a terminal cell.
+func (brailleRenderer) EncodeCell(rc *RenderContext, cell Cell) string {
+	return rc.Image.encodeBrailleCell(rc, cell)
+}
+
+// mosaicRenderer renders sizeY x MosaicSizeX pixels per terminal cell as mosaic glyphs.
+type mosaicRenderer struct {
+	rawPixels
+	resetRowEnd
+	sizeY int
+	glyph func(mask int) rune
+}
+
+// CellSize returns the size in ANSI-pixels (y,x) of each terminal cell.
+func (mr mosaicRenderer) CellSize() (y, x int) {
+	return mr.sizeY, MosaicSizeX
+}
+
+// EncodeCell returns the ANSI-compatible string form of a terminal cell.
+func (mr mosaicRenderer) EncodeCell(rc *RenderContext, cell Cell) string {
+	return rc.Image.encodeMosaicCell(rc, cell, mr.sizeY, mr.glyph)
+}
+
+// glyphRenderer renders BlockSizeY x BlockSizeX pixels per terminal cell as the best matching glyph.
+type glyphRenderer struct {
+	rawPixels
+	resetRowEnd
+}
+
+// CellSize returns the size in ANSI-pixels (y,x) of each terminal cell.
+func (glyphRenderer) CellSize() (y, x int) {
+	return BlockSizeY, BlockSizeX
+}
+
+// EncodeCell returns the ANSI-compatible string form of a terminal cell.
+func (glyphRenderer) EncodeCell(rc *RenderContext, cell Cell) string {
+	return rc.Image.encodeGlyphCell(rc, cell)
+}
@@ -0,0 +1,243 @@
+//       ___  _____  ____
+//      / _ \/  _/ |/_/ /____ ______ _
+//     / ___// /_>  </ __/ -_) __/  ' \
+//    /_/  /___/_/|_|\__/\__/_/ /_/_/_/
+//
+//    Copyright 2017 Eliuk Blau
+//
+//    This Source Code Form is subject to the terms of the Mozilla Public
+//    License, v. 2.0. If a copy of the MPL was not distributed with this
+//    file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package ansimage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image/color"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// charRenderer is a custom Renderer that renders each pixel as a char, lit or unlit by brightness.
+type charRenderer struct {
+	lit, unlit string
+}
+
+func (charRenderer) PixelSize() (y, x int) { return 1, 1 }
+
+func (charRenderer) CellSize() (y, x int) { return 1, 1 }
+
+func (cr charRenderer) EncodeCell(rc *RenderContext, cell Cell) string {
+	if cell.At(0, 0).Brightness > 127 {
+		return cr.lit
+	}
+	return cr.unlit
+}
+
+func (charRenderer) RowEnd(rc *RenderContext) string { return "|" + rc.Newline() }
+
+// registerTestRenderer registers a Renderer for the test, removing it at cleanup.
+func registerTestRenderer(tb testing.TB, dm DitheringMode, r Renderer) {
+	tb.Helper()
+	if _, ok := LookupRenderer(dm); ok {
+		tb.Fatalf("dithering mode %d is already registered", dm)
+	}
+	RegisterRenderer(dm, r)
+	tb.Cleanup(func() {
+		renderersMu.Lock()
+		defer renderersMu.Unlock()
+		delete(renderers, dm)
+	})
+}
+
+func TestRegisterRenderer(t *testing.T) {
+	const dm = DitheringMode(200)
+	registerTestRenderer(t, dm, charRenderer{lit: "#", unlit: "."})
+
+	img := cellImage("f0f", "0f0")
+	ai, err := loadImage(context.Background(), img, Options{DitheringMode: dm})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ai.Render(), "#.#|\n.#.|\n"; got != want {
+		t.Errorf("custom renderer renders %q, want %q", got, want)
+	}
+
+	// registering it again replaces the renderer of new images
+	RegisterRenderer(dm, charRenderer{lit: "x", unlit: " "})
+	if r, ok := LookupRenderer(dm); !ok || r != (charRenderer{lit: "x", unlit: " "}) {
+		t.Fatalf("LookupRenderer = %v, %v, want the last registered renderer", r, ok)
+	}
+	ai, err = loadImage(context.Background(), img, Options{DitheringMode: dm})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ai.Render(), "x x|\n x |\n"; got != want {
+		t.Errorf("replaced renderer renders %q, want %q", got, want)
+	}
+}
+
+func TestLookupUnknownRenderer(t *testing.T) {
+	const dm = DitheringMode(201)
+	if r, ok := LookupRenderer(dm); ok || r != nil {
+		t.Errorf("LookupRenderer(%d) = %v, %v, want no renderer", dm, r, ok)
+	}
+	if _, err := New(2, 2, nil, dm); !errors.Is(err, ErrUnknownDitheringMode) {
+		t.Errorf("New error = %v, want %v", err, ErrUnknownDitheringMode)
+	}
+	if _, err := loadImage(context.Background(), testImage(2, 2), Options{DitheringMode: dm}); !errors.Is(err, ErrUnknownDitheringMode) {
+		t.Errorf("loadImage error = %v, want %v", err, ErrUnknownDitheringMode)
+	}
+}
+
+// termCell is a terminal cell as it is shown: its glyph with background and foreground
+// colors, in the form of SGR parameters ("" for default colors of terminal).
+type termCell struct {
+	bg, fg string
+	glyph  rune
+}
+
+// parseSGR returns the terminal cells shown by ANSI-compatible text, row by row, parsing its
+// SGR color sequences. Cells that show a single color are normalized as a space of that color.
+func parseSGR(tb testing.TB, text string) [][]termCell {
+	tb.Helper()
+	var (
+		rows   [][]termCell
+		row    []termCell
+		bg, fg string
+	)
+	for len(text) > 0 {
+		switch {
+		case strings.HasPrefix(text, "\033["):
+			end := strings.IndexByte(text, 'm')
+			if end < 0 {
+				tb.Fatalf("unterminated escape sequence %q", text)
+			}
+			params := strings.Split(text[2:end], ";")
+			text = text[end+1:]
+			for i := 0; i < len(params); i++ {
+				n, err := strconv.Atoi(params[i])
+				if err != nil {
+					tb.Fatalf("invalid SGR parameter %q", params[i])
+				}
+				switch {
+				case n == 0:
+					bg, fg = "", ""
+				case n == 39:
+					fg = ""
+				case n == 49:
+					bg = ""
+				case n == 38 || n == 48:
+					size := 3 // 5;index
+					if params[i+1] == "2" {
+						size = 5 // 2;r;g;b
+					}
+					c := strings.Join(params[i+1:i+size], ";")
+					if n == 38 {
+						fg = c
+					} else {
+						bg = c
+					}
+					i += size - 1
+				case n >= 30 && n <= 37, n >= 90 && n <= 97:
+					fg = fmt.Sprintf("16;%d", n%10+(n/90)*8)
+				case n >= 40 && n <= 47, n >= 100 && n <= 107:
+					bg = fmt.Sprintf("16;%d", n%10+(n/100)*8)
+				default:
+					tb.Fatalf("unexpected SGR parameter %d", n)
+				}
+			}
+		case text[0] == '\n':
+			rows, row = append(rows, row), nil
+			text = text[1:]
+		default:
+			r, size := utf8.DecodeRuneInString(text)
+			text = text[size:]
+			switch {
+			case r == ' ' || fg == bg:
+				row = append(row, termCell{bg, bg, ' '})
+			case string(r) == fullBlock:
+				row = append(row, termCell{fg, fg, ' '})
+			default:
+				row = append(row, termCell{bg, fg, r})
+			}
+		}
+	}
+	if row != nil {
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func TestPaintOptimizeSameCells(t *testing.T) {
+	for _, dm := range registeredModes() {
+		for _, cd := range []ColorDepth{ColorDepthTrueColor, ColorDepth256, ColorDepth16} {
+			for _, disableBg := range []bool{false, true} {
+				ai := loadTestImage(t, 6, 12, Options{DitheringMode: dm, ColorDepth: cd})
+
+				var plain, optimized bytes.Buffer
+				if _, err := ai.RenderTo(&plain, RenderOptions{DisableBgColor: disableBg}); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := ai.RenderTo(&optimized, RenderOptions{DisableBgColor: disableBg, Optimize: true}); err != nil {
+					t.Fatal(err)
+				}
+				if optimized.Len() > plain.Len() {
+					t.Errorf("mode %d, depth %d, no background %v: optimized output has %d bytes, more than %d", dm, cd, disableBg, optimized.Len(), plain.Len())
+				}
+
+				want, got := parseSGR(t, plain.String()), parseSGR(t, optimized.String())
+				if len(got) != len(want) {
+					t.Fatalf("mode %d, depth %d, no background %v: optimized output has %d rows, want %d", dm, cd, disableBg, len(got), len(want))
+				}
+				for y := range want {
+					if !slices.Equal(got[y], want[y]) {
+						t.Errorf("mode %d, depth %d, no background %v: row %d shows\n%v\nwant\n%v", dm, cd, disableBg, y, got[y], want[y])
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestPaintRepeatedColors(t *testing.T) {
+	ai, err := New(2, 4, color.Black, NoDithering)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc := &RenderContext{Image: ai, Optimize: true, Tolerance: 4}
+	bg, fg := CellRGB(10, 20, 30), CellRGB(200, 100, 50)
+
+	tests := []struct {
+		bg, fg CellColor
+		glyph  string
+		want   string
+	}{
+		{bg, fg, "x", "\033[48;2;10;20;30;38;2;200;100;50mx"},
+		{bg, fg, "y", "y"},                   // same colors
+		{bg, CellRGB(203, 97, 50), "z", "z"}, // within tolerance
+		{bg, CellRGB(210, 100, 50), "z", "\033[38;2;210;100;50mz"},
+		{bg, CellColor{}, " ", " "}, // space does not show foreground color
+		{CellRGB(1, 2, 3), fg, " ", "\033[48;2;1;2;3m "},
+		{CellRGB(1, 2, 3), fg, "w", "\033[38;2;200;100;50mw"},
+		{CellColor{}, CellColor{}, "v", "\033[49;39mv"}, // default colors
+		{CellColor{}, CellColor{}, "u", "u"},
+	}
+	for i, tt := range tests {
+		if got := rc.Paint(tt.bg, tt.fg, tt.glyph); got != tt.want {
+			t.Errorf("paint %d = %q, want %q", i, got, tt.want)
+		}
+	}
+
+	// after reset, colors are set again
+	rc.Reset()
+	if got, want := rc.Paint(bg, fg, "x"), "\033[48;2;10;20;30;38;2;200;100;50mx"; got != want {
+		t.Errorf("paint after reset = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,274 @@
+//       ___  _____  ____
+//      / _ \/  _/ |/_/ /____ ______ _
+//     / ___// /_>  </ __/ -_) __/  ' \
+//    /_/  /___/_/|_|\__/\__/_/ /_/_/_/
+//
+//    Copyright 2017 Eliuk Blau
+//
+//    This Source Code Form is subject to the terms of the Mozilla Public
+//    License, v. 2.0. If a copy of the MPL was not distributed with this
+//    file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package ansimage
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"sort"
+	"strings"
+)
+
+// SixelMaxColors is the maximum number of color registers used by sixel encoder.
+const SixelMaxColors = 256
+
+// RenderSixel returns the DEC sixel graphics form of an image (usually scaled with ScaleImage).
+// Background color is used to fill when image has transparency; if it has transparency too,
+// transparent pixels are not painted. Colors is the number of color registers (2-256) used
+// to quantize the image. (Nice info for sixel - https://vt100.net/docs/vt3xx-gp/chapter14.html)
+func RenderSixel(img image.Image, bg color.Color, colors int) string {
+	colors = min(max(colors, 2), SixelMaxColors)
+
+	rgba := compositeImage(img, bg)
+	_, _, _, bgA := bg.RGBA()
+	transparent := bgA < 0xffff
+
+	palette := medianCut(rgba, colors)
+	indexes := newSixelIndexer(palette)
+	w, h := rgba.Rect.Dx(), rgba.Rect.Dy()
+
+	var sb strings.Builder
+
+	// DCS introducer with raster attributes (1:1 pixel aspect ratio)
+	p2 := 0 // pixels with value 0 are painted with background color
+	if transparent {
+		p2 = 1 // pixels with value 0 remain at their current color
+	}
+	fmt.Fprintf(&sb, "\033P0;%d;0q\"1;1;%d;%d", p2, w, h)
+
+	// color registers (RGB in percent)
+	for i, c := range palette {
+		fmt.Fprintf(&sb, "#%d;2;%d;%d;%d", i,
+			(int(c.R)*100+127)/255, (int(c.G)*100+127)/255, (int(c.B)*100+127)/255)
+	}
+
+	// pixel data, in bands of 6 rows
+	bands := make([][]byte, len(palette))
+	for y0 := 0; y0 < h; y0 += 6 {
+		used := make([]bool, len(palette))
+		for i := range bands {
+			bands[i] = nil
+		}
+
+		for dy := 0; dy < 6 && y0+dy < h; dy++ {
+			for x := 0; x < w; x++ {
+				o := rgba.PixOffset(x, y0+dy)
+				if transparent && rgba.Pix[o+3] < 0x80 {
+					continue
+				}
+				i := indexes.index(rgba.Pix[o], rgba.Pix[o+1], rgba.Pix[o+2])
+				if !used[i] {
+					used[i] = true
+					bands[i] = make([]byte, w)
+				}
+				bands[i][x] |= 1 << dy
+			}
+		}
+
+		first := true
+		for i, band := range bands {
+			if !used[i] {
+				continue
+			}
+			if !first {
+				sb.WriteByte('$') // graphics carriage return
+			}
+			first = false
+			fmt.Fprintf(&sb, "#%d", i)
+			writeSixelRuns(&sb, band)
+		}
+		sb.WriteByte('-') // graphics new line
+	}
+
+	sb.WriteString("\033\\") // string terminator
+	return sb.String()
+}
+
+// DrawSixel writes the DEC sixel graphics form of an image to standard output (terminal).
+func DrawSixel(img image.Image, bg color.Color, colors int) {
+	fmt.Print(RenderSixel(img, bg, colors))
+}
+
+// writeSixelRuns writes a row of sixels, using run-length encoding for repeated sixels.
+// Trailing empty sixels are omitted.
+func writeSixelRuns(sb *strings.Builder, band []byte) {
+	end := len(band)
+	for end > 0 && band[end-1] == 0 {
+		end--
+	}
+
+	for x := 0; x < end; {
+		n := 1
+		for x+n < end && band[x+n] == band[x] {
+			n++
+		}
+		ch := byte(63 + band[x])
+		if n > 3 {
+			fmt.Fprintf(sb, "!%d%c", n, ch)
+		} else {
+			for i := 0; i < n; i++ {
+				sb.WriteByte(ch)
+			}
+		}
+		x += n
+	}
+}
+
+// sixelIndexer maps colors to the nearest palette index.
+// Colors are reduced to 5 bits per channel to cache the results.
+type sixelIndexer struct {
+	palette []color.RGBA
+	cache   [1 << 15]int16
+}
+
+// newSixelIndexer creates a sixelIndexer for the given palette.
+func newSixelIndexer(palette []color.RGBA) *sixelIndexer {
+	si := &sixelIndexer{palette: palette}
+	for i := range si.cache {
+		si.cache[i] = -1
+	}
+	return si
+}
+
+// index returns the palette index of the nearest color to (r,g,b).
+func (si *sixelIndexer) index(r, g, b uint8) int {
+	key := int(r>>3)<<10 | int(g>>3)<<5 | int(b>>3)
+	if i := si.cache[key]; i >= 0 {
+		return int(i)
+	}
+
+	best, bestDist := 0, -1
+	for i, c := range si.palette {
+		dr, dg, db := int(r)-int(c.R), int(g)-int(c.G), int(b)-int(c.B)
+		if dist := 2*dr*dr + 4*dg*dg + 3*db*db; bestDist < 0 || dist < bestDist { // weighted RGB
+			best, bestDist = i, dist
+		}
+	}
+
+	si.cache[key] = int16(best)
+	return best
+}
+
+// colorBox is a box of colors in RGB space, used by median cut quantizer.
+type colorBox struct {
+	colors []histColor
+}
+
+// histColor is a color of the histogram (5 bits per channel) with the sum of its real values.
+type histColor struct {
+	key              [3]uint8
+	count            int
+	sumR, sumG, sumB int
+}
+
+// medianCut quantizes the colors of an image in a palette with n colors at most.
+// Transparent pixels are ignored, as they are not painted by RenderSixel.
+// (info - https://en.wikipedia.org/wiki/Median_cut)
+func medianCut(img *image.NRGBA, n int) []color.RGBA {
+	hist := make(map[int]*histColor)
+	for y := 0; y < img.Rect.Dy(); y++ {
+		for x := 0; x < img.Rect.Dx(); x++ {
+			o := img.PixOffset(img.Rect.Min.X+x, img.Rect.Min.Y+y)
+			if img.Pix[o+3] < 0x80 {
+				continue
+			}
+			r, g, b := img.Pix[o], img.Pix[o+1], img.Pix[o+2]
+			key := int(r>>3)<<10 | int(g>>3)<<5 | int(b>>3)
+			hc, ok := hist[key]
+			if !ok {
+				hc = &histColor{key: [3]uint8{r >> 3, g >> 3, b >> 3}}
+				hist[key] = hc
+			}
+			hc.count++
+			hc.sumR += int(r)
+			hc.sumG += int(g)
+			hc.sumB += int(b)
+		}
+	}
+
+	all := make([]histColor, 0, len(hist))
+	for _, hc := range hist {
+		all = append(all, *hc)
+	}
+	sort.Slice(all, func(i, j int) bool { // deterministic output
+		a, b := all[i].key, all[j].key
+		return int(a[0])<<10|int(a[1])<<5|int(a[2]) < int(b[0])<<10|int(b[1])<<5|int(b[2])
+	})
+
+	boxes := []colorBox{{colors: all}}
+	for len(boxes) < n {
+		// split the box with the widest channel range
+		split, channel, widest := -1, 0, 0
+		for i, box := range boxes {
+			if len(box.colors) < 2 {
+				continue
+			}
+			if c, r := box.widestChannel(); r > widest {
+				split, channel, widest = i, c, r
+			}
+		}
+		if split < 0 {
+			break // every box has a single color
+		}
+
+		box := boxes[split]
+		sort.SliceStable(box.colors, func(i, j int) bool {
+			return box.colors[i].key[channel] < box.colors[j].key[channel]
+		})
+		total := 0
+		for _, hc := range box.colors {
+			total += hc.count
+		}
+		median, acc := 1, 0
+		for i, hc := range box.colors[:len(box.colors)-1] {
+			if acc += hc.count; acc >= total/2 {
+				median = i + 1
+				break
+			}
+		}
+		boxes[split] = colorBox{colors: box.colors[:median]}
+		boxes = append(boxes, colorBox{colors: box.colors[median:]})
+	}
+
+	palette := make([]color.RGBA, 0, len(boxes))
+	for _, box := range boxes {
+		var count, sumR, sumG, sumB int
+		for _, hc := range box.colors {
+			count += hc.count
+			sumR += hc.sumR
+			sumG += hc.sumG
+			sumB += hc.sumB
+		}
+		if count == 0 {
+			continue
+		}
+		palette = append(palette, color.RGBA{
+			uint8(sumR / count), uint8(sumG / count), uint8(sumB / count), 0xff,
+		})
+	}
+	return palette
+}
+
+// widestChannel returns the RGB channel with the widest range in the box, and its range.
+func (cb colorBox) widestChannel() (channel, width int) {
+	for c := 0; c < 3; c++ {
+		lo, hi := uint8(255), uint8(0)
+		for _, hc := range cb.colors {
+			lo, hi = min(lo, hc.key[c]), max(hi, hc.key[c])
+		}
+		if int(hi-lo) > width {
+			channel, width = c, int(hi-lo)
+		}
+	}
+	return channel, width
+}
@@ -0,0 +1,111 @@
+//       ___  _____  ____
+//      / _ \/  _/ |/_/ /____ ______ _
+//     / ___// /_>  </ __/ -_) __/  ' \
+//    /_/  /___/_/|_|\__/\__/_/ /_/_/_/
+//
+//    Copyright 2017 Eliuk Blau
+//
+//    This Source Code Form is subject to the terms of the Mozilla Public
+//    License, v. 2.0. If a copy of the MPL was not distributed with this
+//    file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package ansimage
+
+import (
+	"image"
+	"image/color"
+	"regexp"
+	"testing"
+)
+
+var (
+	testRed  = color.NRGBA{0xff, 0x00, 0x00, 0xff}
+	testBlue = color.NRGBA{0x00, 0x00, 0xff, 0xff}
+)
+
+// pixelImage returns an image with the colors of pixels, one string per row;
+// pixels are 'r' (red), 'b' (blue) or '.' (transparent).
+func pixelImage(rows ...string) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, len(rows[0]), len(rows)))
+	for y, row := range rows {
+		for x, p := range row {
+			switch p {
+			case 'r':
+				img.Set(x, y, testRed)
+			case 'b':
+				img.Set(x, y, testBlue)
+			}
+		}
+	}
+	return img
+}
+
+func TestRenderSixel(t *testing.T) {
+	tests := []struct {
+		name   string
+		img    *image.NRGBA
+		bg     color.Color
+		colors int
+		want   string
+	}{
+		{
+			"two colors", pixelImage("rrb", "rbb"), color.Black, 16,
+			"\033P0;0;0q\"1;1;3;2#0;2;0;0;100#1;2;100;0;0#0?AB$#1B@-\033\\",
+		},
+		{
+			"run length", pixelImage("rrrrrrrrrr"), color.Black, 16,
+			"\033P0;0;0q\"1;1;10;1#0;2;100;0;0#0!10@-\033\\",
+		},
+		{
+			"bands", pixelImage("r", "r", "r", "r", "r", "r", "r"), color.Black, 16,
+			"\033P0;0;0q\"1;1;1;7#0;2;100;0;0#0~-#0@-\033\\",
+		},
+		{
+			"transparent", pixelImage("r.", ".r"), color.Transparent, 16,
+			"\033P0;1;0q\"1;1;2;2#0;2;100;0;0#0@A-\033\\",
+		},
+		{
+			"transparent on background", pixelImage("r.", ".r"), color.White, 16,
+			"\033P0;0;0q\"1;1;2;2#0;2;100;0;0#1;2;100;100;100#0@A$#1A@-\033\\",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RenderSixel(tt.img, tt.bg, tt.colors); got != tt.want {
+				t.Errorf("RenderSixel = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderSixelColors(t *testing.T) {
+	reRegister := regexp.MustCompile(`#[0-9]+;2;[0-9]+;[0-9]+;[0-9]+`)
+	img := testImage(32, 32)
+	for _, colors := range []int{-1, 2, 16, 256, 1000} {
+		out := RenderSixel(img, color.Black, colors)
+		want := min(max(colors, 2), SixelMaxColors)
+		if n := len(reRegister.FindAllString(out, -1)); n == 0 || n > want {
+			t.Errorf("colors %d: %d color registers, want 1..%d", colors, n, want)
+		}
+	}
+}
+
+func TestMedianCutTransparent(t *testing.T) {
+	img := pixelImage("r.r.", ".b.b")
+	for i := range img.Pix { // transparent pixels keep a color, they are not painted anyway
+		if i%4 == 3 && img.Pix[i] == 0 {
+			img.Pix[i-3], img.Pix[i-2], img.Pix[i-1] = 0x00, 0xff, 0x00
+		}
+	}
+
+	palette := medianCut(img, 16)
+	want := []color.RGBA{{0x00, 0x00, 0xff, 0xff}, {0xff, 0x00, 0x00, 0xff}}
+	if len(palette) != len(want) {
+		t.Fatalf("palette = %v, want %v", palette, want)
+	}
+	for i := range want {
+		if palette[i] != want[i] {
+			t.Errorf("palette[%d] = %v, want %v", i, palette[i], want[i])
+		}
+	}
+}
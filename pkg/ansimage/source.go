@@ -0,0 +1,334 @@
+//       ___  _____  ____
+//      / _ \/  _/ |/_/ /____ ______ _
+//     / ___// /_>  </ __/ -_) __/  ' \
+//    /_/  /___/_/|_|\__/\__/_/ /_/_/_/
+//
+//    Copyright 2017 Eliuk Blau
+//
+//    This Source Code Form is subject to the terms of the Mozilla Public
+//    License, v. 2.0. If a copy of the MPL was not distributed with this
+//    file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package ansimage
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/fs"
+	neturl "net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// StdinSource is the source to read image data from standard input.
+const StdinSource = "-"
+
+// ArchiveSeparator separates the path of an archive and the path of an image inside it
+// (e.g. "archive.zip!/img/a.png").
+const ArchiveSeparator = "!/"
+
+var (
+	// ErrInvalidDataURI occurs when a data URI is malformed or its media type is not an image.
+	ErrInvalidDataURI = errors.New("ANSImage: invalid data URI")
+
+	// ErrInvalidFileURL occurs when a file URL has a remote host or no path.
+	ErrInvalidFileURL = errors.New("ANSImage: invalid file URL")
+)
+
+// Source is a source of image data.
+type Source interface {
+	// Open returns a reader of image data, which must be closed after reading it.
+	// The context cancels the opening (e.g. image download).
+	Open(ctx context.Context) (io.ReadCloser, error)
+}
+
+// FileSource is the name of an image file.
+type FileSource string
+
+// Open opens the image file.
+func (s FileSource) Open(ctx context.Context) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return os.Open(string(s))
+}
+
+// URLSource is an image URL (HTTP/HTTPS) downloaded with Fetcher (nil is DefaultFetcher).
+type URLSource struct {
+	URL     string
+	Fetcher *Fetcher
+}
+
+// Open downloads the image URL (or gets it from cache of Fetcher).
+func (s URLSource) Open(ctx context.Context) (io.ReadCloser, error) {
+	fetcher := s.Fetcher
+	if fetcher == nil {
+		fetcher = DefaultFetcher
+	}
+	data, err := fetcher.Fetch(ctx, s.URL)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// DataSource is a data URI with image data ("data:[<media type>][;base64],<data>").
+// Media type must be an image, or empty.
+type DataSource string
+
+// Open decodes the data URI.
+func (s DataSource) Open(ctx context.Context) (io.ReadCloser, error) {
+	data, err := readDataURI(string(s))
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// ReaderSource is an io.Reader with image data. It can be read only once,
+// and it is not closed after reading it (even if it is an io.ReadCloser).
+type ReaderSource struct {
+	Reader io.Reader
+}
+
+// Open returns the reader.
+func (s ReaderSource) Open(ctx context.Context) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(s.Reader), nil
+}
+
+// FSSource is an image file in a file system (e.g. embed.FS).
+type FSSource struct {
+	FS   fs.FS
+	Name string
+}
+
+// Open opens the image file in the file system.
+func (s FSSource) Open(ctx context.Context) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.FS.Open(s.Name)
+}
+
+// ArchiveSource is an image file inside a zip or tar archive (optionally gzipped)
+// read from another source. Zip archives are read in memory (they need random access).
+type ArchiveSource struct {
+	Archive Source
+	Name    string
+}
+
+// archive formats detected by their magic numbers
+var (
+	zipMagic  = []byte("PK\x03\x04")
+	gzipMagic = []byte{0x1f, 0x8b}
+)
+
+// Open opens the image file inside the archive.
+func (s ArchiveSource) Open(ctx context.Context) (io.ReadCloser, error) {
+	name := strings.TrimPrefix(path.Clean("/"+s.Name), "/")
+	notFound := &fs.PathError{Op: "open", Path: s.Name, Err: fs.ErrNotExist}
+
+	archive, err := s.Archive.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(archive)
+	magic, _ := reader.Peek(len(zipMagic))
+
+	if bytes.HasPrefix(magic, zipMagic) {
+		data, err := io.ReadAll(reader)
+		archive.Close()
+		if err != nil {
+			return nil, err
+		}
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+		file, err := zr.Open(name)
+		if err != nil {
+			return nil, notFound
+		}
+		return file, nil
+	}
+
+	var tarReader io.Reader = reader
+	if bytes.HasPrefix(magic, gzipMagic) {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			archive.Close()
+			return nil, err
+		}
+		tarReader = gz
+	}
+	tr := tar.NewReader(tarReader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			archive.Close()
+			return nil, notFound
+		}
+		if err != nil {
+			archive.Close()
+			return nil, err
+		}
+		if header.Typeflag == tar.TypeReg && strings.TrimPrefix(path.Clean("/"+header.Name), "/") == name {
+			return struct {
+				io.Reader
+				io.Closer
+			}{tr, archive}, nil
+		}
+	}
+}
+
+// ParseSource returns the Source of a string: an image file, standard input ("-"),
+// an image URL (HTTP/HTTPS) downloaded with Fetcher (nil is DefaultFetcher),
+// a data URI ("data:image/png;base64,..."), a file URL ("file:///path/to/image"),
+// or an image file inside a zip or tar archive of one of them ("archive.zip!/img/a.png").
+// Archives in URLs must have a known extension (.zip, .cbz, .tar, .tar.gz or .tgz), because "!/"
+// is valid in URL paths.
+func ParseSource(source string, f *Fetcher) (Source, error) {
+	if archive, name, ok := splitArchive(source); ok {
+		src, err := ParseSource(archive, f)
+		if err != nil {
+			return nil, err
+		}
+		return ArchiveSource{Archive: src, Name: name}, nil
+	}
+
+	switch {
+	case source == StdinSource:
+		return ReaderSource{Reader: os.Stdin}, nil
+	case hasScheme(source, "http"), hasScheme(source, "https"):
+		return URLSource{URL: source, Fetcher: f}, nil
+	case hasScheme(source, "data"):
+		return DataSource(source), nil
+	case hasScheme(source, "file"):
+		name, err := fileURLPath(source)
+		if err != nil {
+			return nil, err
+		}
+		return FileSource(name), nil
+	}
+	return FileSource(source), nil
+}
+
+// ReadSource reads the image data of a source string (see ParseSource).
+func ReadSource(ctx context.Context, source string, f *Fetcher) ([]byte, error) {
+	src, err := ParseSource(source, f)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := src.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// splitArchive returns the archive and the image name of a source with ArchiveSeparator,
+// and reports if it is an image inside an archive: URLs (HTTP/HTTPS and file) only if the
+// archive has a known extension, and other sources if they are not a file with separator in its name.
+func splitArchive(source string) (archive, name string, ok bool) {
+	i := strings.LastIndex(source, ArchiveSeparator)
+	if i <= 0 || hasScheme(source, "data") {
+		return "", "", false
+	}
+	archive, name = source[:i], source[i+len(ArchiveSeparator):]
+
+	if hasScheme(archive, "http") || hasScheme(archive, "https") || hasScheme(archive, "file") {
+		u, err := neturl.Parse(archive)
+		if err != nil || !hasArchiveExt(u.Path) {
+			return "", "", false
+		}
+		return archive, name, true
+	}
+	if _, err := os.Stat(source); err == nil {
+		return "", "", false
+	}
+	return archive, name, true
+}
+
+// archiveExts are the file extensions of archives in URLs of sources.
+var archiveExts = []string{".zip", ".cbz", ".tar", ".tar.gz", ".tgz"}
+
+// hasArchiveExt reports if a path has a file extension of archives (case-insensitive).
+func hasArchiveExt(path string) bool {
+	path = strings.ToLower(path)
+	return slices.ContainsFunc(archiveExts, func(ext string) bool {
+		return strings.HasSuffix(path, ext)
+	})
+}
+
+// hasScheme reports if a source is an URL with the scheme (case-insensitive).
+func hasScheme(source, scheme string) bool {
+	return len(source) > len(scheme) && source[len(scheme)] == ':' &&
+		strings.EqualFold(source[:len(scheme)], scheme)
+}
+
+// readDataURI returns the data of a data URI ("data:[<media type>][;base64],<data>").
+// Media type must be an image, or empty.
+func readDataURI(uri string) ([]byte, error) {
+	header, payload, ok := strings.Cut(uri[len("data:"):], ",")
+	if !ok {
+		return nil, ErrInvalidDataURI
+	}
+	mediaType, params, _ := strings.Cut(header, ";")
+	if mediaType != "" && !strings.HasPrefix(strings.ToLower(mediaType), "image/") {
+		return nil, ErrInvalidDataURI
+	}
+
+	if strings.HasSuffix(strings.ToLower(params), "base64") {
+		payload = strings.Map(func(r rune) rune {
+			if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+				return -1 // line breaks are common in long URIs
+			}
+			return r
+		}, payload)
+		data, err := base64.StdEncoding.DecodeString(payload)
+		if err != nil {
+			data, err = base64.RawStdEncoding.DecodeString(payload) // without padding
+		}
+		if err != nil {
+			return nil, ErrInvalidDataURI
+		}
+		return data, nil
+	}
+
+	data, err := neturl.PathUnescape(payload)
+	if err != nil {
+		return nil, ErrInvalidDataURI
+	}
+	return []byte(data), nil
+}
+
+// fileURLPath returns the local path of a file URL ("file:///path" or "file://localhost/path").
+func fileURLPath(rawURL string) (string, error) {
+	u, err := neturl.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if (u.Host != "" && u.Host != "localhost") || u.Path == "" {
+		return "", ErrInvalidFileURL
+	}
+	path := u.Path
+	if runtime.GOOS == "windows" && len(path) > 2 && path[0] == '/' && path[2] == ':' {
+		path = path[1:] // "/C:/path" --> "C:/path"
+	}
+	return filepath.FromSlash(path), nil
+}
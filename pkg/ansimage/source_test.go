@@ -0,0 +1,58 @@
+//       ___  _____  ____
+//      / _ \/  _/ |/_/ /____ ______ _
+//     / ___// /_>  </ __/ -_) __/  ' \
+//    /_/  /___/_/|_|\__/\__/_/ /_/_/_/
+//
+//    Copyright 2017 Eliuk Blau
+//
+//    This Source Code Form is subject to the terms of the Mozilla Public
+//    License, v. 2.0. If a copy of the MPL was not distributed with this
+//    file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package ansimage
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseSource(t *testing.T) {
+	dir := t.TempDir()
+	bang := filepath.Join(dir, "a!", "b.png") // existing file with separator in its name
+	if err := os.MkdirAll(filepath.Dir(bang), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(bang, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		source string
+		want   Source
+	}{
+		{"img.png", FileSource("img.png")},
+		{"-", ReaderSource{Reader: os.Stdin}},
+		{"https://host/img.png", URLSource{URL: "https://host/img.png"}},
+		{"https://host/foo!/bar.png", URLSource{URL: "https://host/foo!/bar.png"}},
+		{"http://host/a.ZIP!/img/a.png", ArchiveSource{Archive: URLSource{URL: "http://host/a.ZIP"}, Name: "img/a.png"}},
+		{"https://host/a.tar.gz?v=1!/a.png", ArchiveSource{Archive: URLSource{URL: "https://host/a.tar.gz?v=1"}, Name: "a.png"}},
+		{"file:///tmp/foo!/bar.png", FileSource(filepath.FromSlash("/tmp/foo!/bar.png"))},
+		{"file:///tmp/a.tgz!/bar.png", ArchiveSource{Archive: FileSource(filepath.FromSlash("/tmp/a.tgz")), Name: "bar.png"}},
+		{"archive.zip!/img/a.png", ArchiveSource{Archive: FileSource("archive.zip"), Name: "img/a.png"}},
+		{"outer.tar!/inner.zip!/a.png", ArchiveSource{Archive: ArchiveSource{Archive: FileSource("outer.tar"), Name: "inner.zip"}, Name: "a.png"}},
+		{bang, FileSource(bang)},
+		{"data:image/png;base64,iVBO!/x", DataSource("data:image/png;base64,iVBO!/x")},
+	}
+	for _, tt := range tests {
+		got, err := ParseSource(tt.source, nil)
+		if err != nil {
+			t.Errorf("ParseSource(%q): %v", tt.source, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseSource(%q) = %#v, want %#v", tt.source, got, tt.want)
+		}
+	}
+}
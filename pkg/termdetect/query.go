@@ -0,0 +1,168 @@
+//       ___  _____  ____
+//      / _ \/  _/ |/_/ /____ ______ _
+//     / ___// /_>  </ __/ -_) __/  ' \
+//    /_/  /___/_/|_|\__/\__/_/ /_/_/_/
+//
+//    Copyright 2017 Eliuk Blau
+//
+//    This Source Code Form is subject to the terms of the Mozilla Public
+//    License, v. 2.0. If a copy of the MPL was not distributed with this
+//    file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package termdetect
+
+import (
+	"errors"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// Terminal queries. Every terminal answers to DA1 (Primary Device Attributes),
+// so it is sent last: when its answer arrives, all other answers were received.
+// All queries are sent at once, so the terminal is queried in one round-trip.
+// (info - https://invisible-island.net/xterm/ctlseqs/ctlseqs.html)
+// (info - https://sw.kovidgoyal.net/kitty/graphics-protocol/#querying-support-and-available-transmission-mediums)
+const (
+	queryKitty     = "\033_Gi=31,s=1,v=1,a=q,t=d,f=24;AAAA\033\\"
+	queryXTGETTCAP = "\033P+q524742;5463\033\\" // "RGB" and "Tc" capabilities (hex encoded)
+	queryCellSize  = "\033[16t"                 // XTWINOPS: report character cell size in pixels
+	queryDA1       = "\033[c"
+)
+
+// drainTimeout is the time to wait for late answers after query timeout (e.g. over ssh),
+// reading them before terminal is restored, so they don't land in the input of the shell.
+const drainTimeout = time.Second
+
+var (
+	// ErrNoAnswer occurs when the terminal does not answer the queries before timeout.
+	ErrNoAnswer = errors.New("termdetect: no answer from terminal")
+
+	reDA1       = regexp.MustCompile(`\x1b\[\?([0-9;]*)c`)
+	reKitty     = regexp.MustCompile(`\x1b_Gi=31;OK\x1b\\`)
+	reXTGETTCAP = regexp.MustCompile(`\x1bP1\+r(524742|5463)`)
+	reCellSize  = regexp.MustCompile(`\x1b\[6;([0-9]+);([0-9]+)t`)
+)
+
+// Query asks the controlling terminal (/dev/tty) for its capabilities and the size
+// of its character cells, using escape sequences, and parses its answers read in raw
+// mode before timeout.
+func Query(timeout time.Duration) (Capabilities, error) {
+	answer, err := query(queryKitty+queryXTGETTCAP+queryCellSize, timeout)
+	if err != nil {
+		return Capabilities{}, err
+	}
+	return ParseAnswer(answer), nil
+}
+
+// QueryCellSize asks the controlling terminal (/dev/tty) for the size of its
+// character cells in pixels. It is needed to scale images for graphics protocols.
+// Query gets it too, in the same round-trip as the capabilities.
+func QueryCellSize(timeout time.Duration) (width, height int, err error) {
+	answer, err := query(queryCellSize, timeout)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	width, height = parseCellSize(answer)
+	if width == 0 || height == 0 {
+		return 0, 0, ErrNoAnswer
+	}
+	return width, height, nil
+}
+
+// parseCellSize returns the size of character cells in the answer to XTWINOPS query (0 if not found).
+func parseCellSize(answer string) (width, height int) {
+	m := reCellSize.FindStringSubmatch(answer)
+	if m == nil {
+		return 0, 0
+	}
+	height, _ = strconv.Atoi(m[1])
+	width, _ = strconv.Atoi(m[2])
+	if width <= 0 || height <= 0 {
+		return 0, 0
+	}
+	return width, height
+}
+
+// query writes queries to the controlling terminal in raw mode, followed by DA1,
+// and returns the answers.
+func query(queries string, timeout time.Duration) (string, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", err
+	}
+	defer tty.Close()
+
+	state, err := term.MakeRaw(int(tty.Fd()))
+	if err != nil {
+		return "", err
+	}
+	defer term.Restore(int(tty.Fd()), state)
+
+	if _, err := tty.WriteString(queries + queryDA1); err != nil {
+		return "", err
+	}
+	return readAnswer(tty, timeout, drainTimeout)
+}
+
+// ParseAnswer returns the terminal capabilities found in the answers to queries.
+func ParseAnswer(answer string) Capabilities {
+	var caps Capabilities
+
+	if m := reDA1.FindStringSubmatch(answer); m != nil {
+		for _, attr := range strings.Split(m[1], ";") {
+			if attr == "4" { // sixel graphics
+				caps.Sixel = true
+			}
+		}
+	}
+	if reKitty.MatchString(answer) {
+		caps.Kitty, caps.TrueColor = true, true
+	}
+	if reXTGETTCAP.MatchString(answer) {
+		caps.TrueColor = true
+	}
+	caps.CellWidth, caps.CellHeight = parseCellSize(answer)
+
+	return caps
+}
+
+// readAnswer reads from terminal until the answer to DA1 arrives or timeout.
+// If timeout is reached, late answers are drained until the answer to DA1 arrives
+// or drain timeout, and they are ignored. The terminal file is read in another
+// goroutine; if drain timeout is reached, the caller must close the file to release it.
+func readAnswer(tty *os.File, timeout, drain time.Duration) (string, error) {
+	done := make(chan string, 1)
+	go func() {
+		var answer []byte
+		buf := make([]byte, 256)
+		for {
+			n, err := tty.Read(buf)
+			answer = append(answer, buf[:n]...)
+			if err != nil || reDA1.Match(answer) {
+				done <- string(answer)
+				return
+			}
+		}
+	}()
+
+	select {
+	case answer := <-done:
+		if !reDA1.MatchString(answer) {
+			return "", ErrNoAnswer
+		}
+		return answer, nil
+	case <-time.After(timeout):
+	}
+
+	select {
+	case <-done:
+	case <-time.After(drain):
+	}
+	return "", ErrNoAnswer
+}
@@ -0,0 +1,127 @@
+//       ___  _____  ____
+//      / _ \/  _/ |/_/ /____ ______ _
+//     / ___// /_>  </ __/ -_) __/  ' \
+//    /_/  /___/_/|_|\__/\__/_/ /_/_/_/
+//
+//    Copyright 2017 Eliuk Blau
+//
+//    This Source Code Form is subject to the terms of the Mozilla Public
+//    License, v. 2.0. If a copy of the MPL was not distributed with this
+//    file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package termdetect
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+// Answers of terminals to the queries.
+const (
+	answerDA1      = "\033[?62;22c"
+	answerDA1Sixel = "\033[?63;1;2;4;6;9;15;22c"
+	answerKitty    = "\033_Gi=31;OK\033\\"
+	answerRGB      = "\033P1+r524742=382F382F38\033\\"
+	answerTc       = "\033P1+r5463\033\\"
+	answerNoTcap   = "\033P0+r524742\033\\"
+	answerCellSize = "\033[6;20;10t"
+)
+
+func TestParseAnswer(t *testing.T) {
+	tests := []struct {
+		name   string
+		answer string
+		want   Capabilities
+	}{
+		{"empty", "", Capabilities{}},
+		{"da1", answerDA1, Capabilities{}},
+		{"da1 sixel", answerDA1Sixel, Capabilities{Sixel: true}},
+		{"da1 sixel last", "\033[?64;4c", Capabilities{Sixel: true}},
+		{"da1 attribute 44", "\033[?62;44c", Capabilities{}},
+		{"kitty", answerKitty + answerDA1, Capabilities{Kitty: true, TrueColor: true}},
+		{"kitty error", "\033_Gi=31;ENOTSUPPORTED:no\033\\" + answerDA1, Capabilities{}},
+		{"xtgettcap rgb", answerRGB + answerDA1, Capabilities{TrueColor: true}},
+		{"xtgettcap tc", answerTc + answerDA1, Capabilities{TrueColor: true}},
+		{"xtgettcap invalid", answerNoTcap + answerDA1, Capabilities{}},
+		{"cell size", answerCellSize + answerDA1, Capabilities{CellWidth: 10, CellHeight: 20}},
+		{"all", answerKitty + answerRGB + answerCellSize + answerDA1Sixel, Capabilities{Kitty: true, TrueColor: true, Sixel: true, CellWidth: 10, CellHeight: 20}},
+		{"truncated kitty", "\033_Gi=31;O", Capabilities{}},
+		{"truncated xtgettcap", "\033P1+r52", Capabilities{}},
+		{"truncated da1", "\033[?63;1;2;4", Capabilities{}},
+		{"truncated cell size", "\033[6;20;10", Capabilities{}},
+		{"garbage", "\033[?;;c\033[6;x;yt\033P1+rzz\033\\\x00\xff", Capabilities{}},
+		{"zero cell size", "\033[6;0;10t", Capabilities{}},
+		{"keys typed before", "abc\r" + answerKitty + answerDA1, Capabilities{Kitty: true, TrueColor: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseAnswer(tt.answer); got != tt.want {
+				t.Errorf("ParseAnswer(%q) = %+v, want %+v", tt.answer, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadAnswer(t *testing.T) {
+	tests := []struct {
+		name    string
+		writes  []string
+		close   bool // closes the terminal after writes
+		want    string
+		wantErr error
+	}{
+		{"da1", []string{answerDA1}, false, answerDA1, nil},
+		{"split", []string{answerKitty + "\033[?6", "2;22c"}, false, answerKitty + answerDA1, nil},
+		{"no da1", []string{answerKitty}, false, "", ErrNoAnswer},
+		{"truncated and closed", []string{answerKitty + "\033[?62"}, true, "", ErrNoAnswer},
+		{"silent", nil, false, "", ErrNoAnswer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, w, err := os.Pipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			defer w.Close()
+
+			for _, s := range tt.writes {
+				if _, err := w.WriteString(s); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.close {
+				w.Close()
+			}
+
+			got, err := readAnswer(r, 50*time.Millisecond, 10*time.Millisecond)
+			if got != tt.want || !errors.Is(err, tt.wantErr) {
+				t.Errorf("readAnswer = %q, %v, want %q, %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadAnswerDrain(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	go func() {
+		time.Sleep(50 * time.Millisecond) // after timeout, before drain timeout
+		w.WriteString(answerDA1)
+	}()
+
+	start := time.Now()
+	if _, err := readAnswer(r, 10*time.Millisecond, 5*time.Second); !errors.Is(err, ErrNoAnswer) {
+		t.Errorf("readAnswer error = %v, want %v", err, ErrNoAnswer)
+	}
+	if elapsed := time.Since(start); elapsed > 4*time.Second {
+		t.Errorf("readAnswer returned after %v, want it to return when the late answer is drained", elapsed)
+	}
+}
@@ -0,0 +1,203 @@
+//       ___  _____  ____
+//      / _ \/  _/ |/_/ /____ ______ _
+//     / ___// /_>  </ __/ -_) __/  ' \
+//    /_/  /___/_/|_|\__/\__/_/ /_/_/_/
+//
+//    Copyright 2017 Eliuk Blau
+//
+//    This Source Code Form is subject to the terms of the Mozilla Public
+//    License, v. 2.0. If a copy of the MPL was not distributed with this
+//    file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package termdetect detects the capabilities of the terminal (colors and
+// graphics protocols) to pick the best output mode to draw images on it.
+package termdetect
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+// Terminal output modes, sorted from worst to best quality:
+// none (plain text without color),
+// 16 colors (basic and bright SGR colors),
+// 256 colors (xterm 256-color palette),
+// true color (24-bit RGB),
+// sixel (DEC sixel graphics),
+// iTerm2 (OSC 1337 inline images protocol),
+// kitty (kitty graphics protocol).
+const (
+	ModeNone = Mode(iota)
+	Mode16
+	Mode256
+	ModeTrueColor
+	ModeSixel
+	ModeITerm2
+	ModeKitty
+)
+
+// DefaultQueryTimeout is the time to wait for the terminal answer to queries.
+const DefaultQueryTimeout = 100 * time.Millisecond
+
+// Mode type is used for terminal output mode constants.
+type Mode uint8
+
+// Capabilities represents the features supported by a terminal.
+type Capabilities struct {
+	Colors256 bool // xterm 256-color palette
+	Colors16  bool // basic and bright SGR colors
+	TrueColor bool // 24-bit RGB colors
+	Sixel     bool // DEC sixel graphics
+	Kitty     bool // kitty graphics protocol
+	ITerm2    bool // iTerm2 inline images protocol
+
+	CellWidth, CellHeight int // size of character cells in pixels (0 if unknown, only queried)
+}
+
+// Options is used to configure the terminal detection.
+type Options struct {
+	// Getenv is used to read environment variables (default: os.Getenv).
+	Getenv func(string) string
+
+	// Query enables to query the terminal with escape sequences (DA1, XTGETTCAP, kitty graphics, XTWINOPS).
+	// It requires a controlling terminal (/dev/tty) that answers in raw mode.
+	Query bool
+
+	// QueryTimeout is the time to wait for the terminal answer (default: DefaultQueryTimeout).
+	QueryTimeout time.Duration
+}
+
+// String returns the name of terminal output mode.
+func (m Mode) String() string {
+	switch m {
+	case ModeNone:
+		return "none"
+	case Mode16:
+		return "16"
+	case Mode256:
+		return "256"
+	case ModeTrueColor:
+		return "truecolor"
+	case ModeSixel:
+		return "sixel"
+	case ModeITerm2:
+		return "iterm2"
+	case ModeKitty:
+		return "kitty"
+	}
+	return "unknown"
+}
+
+// Best returns the best output mode supported by the terminal capabilities.
+func (c Capabilities) Best() Mode {
+	switch {
+	case c.Kitty:
+		return ModeKitty
+	case c.ITerm2:
+		return ModeITerm2
+	case c.Sixel:
+		return ModeSixel
+	case c.TrueColor:
+		return ModeTrueColor
+	case c.Colors256:
+		return Mode256
+	case c.Colors16:
+		return Mode16
+	}
+	return ModeNone
+}
+
+// Merge returns the union of two terminal capabilities.
+func (c Capabilities) Merge(other Capabilities) Capabilities {
+	return Capabilities{
+		Colors256: c.Colors256 || other.Colors256,
+		Colors16:  c.Colors16 || other.Colors16,
+		TrueColor: c.TrueColor || other.TrueColor,
+		Sixel:     c.Sixel || other.Sixel,
+		Kitty:     c.Kitty || other.Kitty,
+		ITerm2:    c.ITerm2 || other.ITerm2,
+
+		CellWidth:  max(c.CellWidth, other.CellWidth),
+		CellHeight: max(c.CellHeight, other.CellHeight),
+	}
+}
+
+// Detect returns the capabilities of the terminal. Environment variables are
+// always inspected; the terminal is also queried if it is enabled in options.
+// A failed query is ignored, and the environment based detection is returned.
+func Detect(opts Options) Capabilities {
+	if opts.Getenv == nil {
+		opts.Getenv = os.Getenv
+	}
+	if opts.QueryTimeout <= 0 {
+		opts.QueryTimeout = DefaultQueryTimeout
+	}
+
+	caps := FromEnv(opts.Getenv)
+	if opts.Query {
+		if queried, err := Query(opts.QueryTimeout); err == nil {
+			caps = caps.Merge(queried)
+		}
+	}
+	return caps
+}
+
+// DetectMode returns the best output mode of the terminal.
+// It is a shortcut for Detect(opts).Best().
+func DetectMode(opts Options) Mode {
+	return Detect(opts).Best()
+}
+
+// FromEnv returns the terminal capabilities guessed from the environment variables
+// COLORTERM, TERM, TERM_PROGRAM, LC_TERMINAL and KITTY_WINDOW_ID. True color is
+// assumed, like pixterm always did, unless the environment proves lower support:
+// TERM=dumb, the VT340 hardware terminal or Apple Terminal without COLORTERM.
+func FromEnv(getenv func(string) string) Capabilities {
+	var caps Capabilities
+
+	term := strings.ToLower(getenv("TERM"))
+	switch {
+	case term == "dumb":
+		// no colors
+	case strings.HasPrefix(term, "xterm-kitty"), strings.HasPrefix(term, "xterm-ghostty"):
+		caps.Kitty, caps.TrueColor = true, true
+	case strings.HasPrefix(term, "foot"), strings.HasPrefix(term, "mlterm"), strings.HasPrefix(term, "contour"):
+		caps.Sixel, caps.TrueColor = true, true
+	case strings.HasPrefix(term, "vt340"):
+		caps.Sixel, caps.Colors16 = true, true
+	case strings.Contains(term, "256color"):
+		caps.Colors256, caps.TrueColor = true, true // most of them support true color without telling
+	default:
+		caps.TrueColor = true // xterm, *-direct, screen, linux, unset (e.g. Windows console)...
+	}
+
+	switch getenv("TERM_PROGRAM") {
+	case "iTerm.app":
+		caps.ITerm2, caps.TrueColor = true, true
+	case "WezTerm":
+		caps.ITerm2, caps.Sixel, caps.TrueColor = true, true, true
+	case "ghostty":
+		caps.Kitty, caps.TrueColor = true, true
+	case "mintty":
+		caps.ITerm2, caps.Sixel, caps.TrueColor = true, true, true
+	case "vscode", "Hyper":
+		caps.TrueColor = true
+	case "Apple_Terminal":
+		caps.Colors256, caps.TrueColor = true, false // unless COLORTERM tells otherwise
+	}
+
+	switch strings.ToLower(getenv("COLORTERM")) {
+	case "truecolor", "24bit":
+		caps.TrueColor = true
+	}
+
+	if getenv("LC_TERMINAL") == "iTerm2" { // it survives ssh sessions
+		caps.ITerm2, caps.TrueColor = true, true
+	}
+	if getenv("KITTY_WINDOW_ID") != "" {
+		caps.Kitty, caps.TrueColor = true, true
+	}
+
+	return caps
+}
@@ -0,0 +1,108 @@
+//       ___  _____  ____
+//      / _ \/  _/ |/_/ /____ ______ _
+//     / ___// /_>  </ __/ -_) __/  ' \
+//    /_/  /___/_/|_|\__/\__/_/ /_/_/_/
+//
+//    Copyright 2017 Eliuk Blau
+//
+//    This Source Code Form is subject to the terms of the Mozilla Public
+//    License, v. 2.0. If a copy of the MPL was not distributed with this
+//    file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package termdetect
+
+import (
+	"testing"
+)
+
+// env returns a getenv function reading the variables of the map.
+func env(vars map[string]string) func(string) string {
+	return func(key string) string { return vars[key] }
+}
+
+func TestFromEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want Mode
+	}{
+		{"unset", nil, ModeTrueColor},
+		{"dumb", map[string]string{"TERM": "dumb"}, ModeNone},
+		{"xterm", map[string]string{"TERM": "xterm"}, ModeTrueColor},
+		{"xterm over ssh", map[string]string{"TERM": "xterm-256color"}, ModeTrueColor},
+		{"screen", map[string]string{"TERM": "screen"}, ModeTrueColor},
+		{"tmux", map[string]string{"TERM": "tmux-256color", "COLORTERM": "truecolor"}, ModeTrueColor},
+		{"linux console", map[string]string{"TERM": "linux"}, ModeTrueColor},
+		{"direct", map[string]string{"TERM": "xterm-direct"}, ModeTrueColor},
+		{"kitty", map[string]string{"TERM": "xterm-kitty"}, ModeKitty},
+		{"kitty window", map[string]string{"TERM": "xterm-256color", "KITTY_WINDOW_ID": "1"}, ModeKitty},
+		{"ghostty", map[string]string{"TERM": "xterm-ghostty"}, ModeKitty},
+		{"ghostty program", map[string]string{"TERM": "xterm-256color", "TERM_PROGRAM": "ghostty"}, ModeKitty},
+		{"foot", map[string]string{"TERM": "foot"}, ModeSixel},
+		{"foot direct", map[string]string{"TERM": "foot-direct"}, ModeSixel},
+		{"mlterm direct", map[string]string{"TERM": "mlterm-direct"}, ModeSixel},
+		{"mlterm 256", map[string]string{"TERM": "mlterm-256color"}, ModeSixel},
+		{"contour", map[string]string{"TERM": "contour"}, ModeSixel},
+		{"vt340", map[string]string{"TERM": "vt340"}, ModeSixel},
+		{"iterm2", map[string]string{"TERM": "xterm-256color", "TERM_PROGRAM": "iTerm.app"}, ModeITerm2},
+		{"iterm2 over ssh", map[string]string{"TERM": "xterm-256color", "LC_TERMINAL": "iTerm2"}, ModeITerm2},
+		{"wezterm", map[string]string{"TERM": "xterm-256color", "TERM_PROGRAM": "WezTerm"}, ModeITerm2},
+		{"mintty", map[string]string{"TERM": "xterm", "TERM_PROGRAM": "mintty"}, ModeITerm2},
+		{"vscode", map[string]string{"TERM": "xterm-256color", "TERM_PROGRAM": "vscode"}, ModeTrueColor},
+		{"apple terminal", map[string]string{"TERM": "xterm-256color", "TERM_PROGRAM": "Apple_Terminal"}, Mode256},
+		{"apple terminal truecolor", map[string]string{"TERM": "xterm-256color", "TERM_PROGRAM": "Apple_Terminal", "COLORTERM": "truecolor"}, ModeTrueColor},
+		{"colorterm 24bit", map[string]string{"TERM": "vt340", "COLORTERM": "24BIT"}, ModeSixel},
+		{"dumb colorterm", map[string]string{"TERM": "dumb", "COLORTERM": "truecolor"}, ModeTrueColor},
+		{"uppercase term", map[string]string{"TERM": "XTERM-KITTY"}, ModeKitty},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			caps := FromEnv(env(tt.env))
+			if got := caps.Best(); got != tt.want {
+				t.Errorf("FromEnv(%v).Best() = %v, want %v (%+v)", tt.env, got, tt.want, caps)
+			}
+		})
+	}
+}
+
+func TestFromEnvColors(t *testing.T) {
+	caps := FromEnv(env(map[string]string{"TERM": "vt340"}))
+	if !caps.Colors16 || caps.TrueColor {
+		t.Errorf("vt340 capabilities = %+v, want 16 colors without true color", caps)
+	}
+	caps = FromEnv(env(map[string]string{"TERM": "xterm-256color"}))
+	if !caps.Colors256 || !caps.TrueColor {
+		t.Errorf("xterm-256color capabilities = %+v, want 256 colors and true color", caps)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	opts := Options{Getenv: env(map[string]string{"TERM": "foot"})} // no query
+	if got := DetectMode(opts); got != ModeSixel {
+		t.Errorf("DetectMode = %v, want %v", got, ModeSixel)
+	}
+}
+
+func TestCapabilitiesMerge(t *testing.T) {
+	a := Capabilities{Colors256: true, CellWidth: 8}
+	b := Capabilities{Sixel: true, CellWidth: 10, CellHeight: 20}
+	want := Capabilities{Colors256: true, Sixel: true, CellWidth: 10, CellHeight: 20}
+	if got := a.Merge(b); got != want {
+		t.Errorf("Merge = %+v, want %+v", got, want)
+	}
+	if got := b.Merge(a); got != want {
+		t.Errorf("Merge = %+v, want %+v", got, want)
+	}
+}
+
+func TestModeString(t *testing.T) {
+	names := []string{"none", "16", "256", "truecolor", "sixel", "iterm2", "kitty"}
+	for m, name := range names {
+		if got := Mode(m).String(); got != name {
+			t.Errorf("Mode(%d).String() = %q, want %q", m, got, name)
+		}
+	}
+	if got := Mode(len(names)).String(); got != "unknown" {
+		t.Errorf("Mode(%d).String() = %q, want %q", len(names), got, "unknown")
+	}
+}